@@ -1,35 +1,107 @@
 package apicache
 
 import (
+	"context"
+	"sync/atomic"
 	"time"
 
 	"github.com/patrickmn/go-cache"
 )
 
-//Cache represents the OpenWeather reponse cache
+//Cache represents the OpenWeather reponse cache. GetValue also returns when the value was stored,
+//so callers can tell how old it is
 type Cache interface {
-	SetValue(id string, v []byte)
-	GetValue(id string) []byte
+	SetValue(ctx context.Context, id string, v []byte)
+	GetValue(ctx context.Context, id string) ([]byte, time.Time)
+	//Delete removes the value stored for id, if any
+	Delete(ctx context.Context, id string)
+	//Flush removes every value
+	Flush(ctx context.Context)
+	Stats(ctx context.Context) Stats
+}
+
+//Pinger is implemented by caches stored in a server, like Redis, to check whether it is reachable
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+//Stats counts cache lookups since the cache was created. Evictions are entries dropped because they expired
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Items     int    `json:"items"`
+}
+
+//HitRatio returns the share of lookups that found a value, or 0 when there were no lookups
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
 }
 
 type apiCache struct {
 	*cache.Cache
+	hits      uint64
+	misses    uint64
+	evictions uint64
 }
 
-//New returns new cache object
+type entry struct {
+	v        []byte
+	storedAt time.Time
+	//deleted tells the eviction callback that the entry was purged, not expired
+	deleted int32
+}
+
+//New returns new cache object. Values expire after d minutes
 func New(d int) Cache {
 	c := cache.New(time.Duration(d)*time.Minute, time.Duration(d+1)*time.Minute)
-	return &apiCache{c}
+	return newAPICache(c)
 }
 
-func (ch *apiCache) SetValue(id string, v []byte) {
-	ch.Set(id, v, cache.DefaultExpiration)
+func newAPICache(c *cache.Cache) *apiCache {
+	ch := &apiCache{Cache: c}
+	c.OnEvicted(func(id string, v interface{}) {
+		if atomic.LoadInt32(&v.(*entry).deleted) == 0 {
+			atomic.AddUint64(&ch.evictions, 1)
+		}
+	})
+	return ch
 }
 
-func (ch *apiCache) GetValue(id string) []byte {
+func (ch *apiCache) SetValue(ctx context.Context, id string, v []byte) {
+	ch.Set(id, &entry{v: v, storedAt: time.Now()}, cache.DefaultExpiration)
+}
+
+func (ch *apiCache) GetValue(ctx context.Context, id string) ([]byte, time.Time) {
 	v, ok := ch.Get(id)
 	if ok {
-		return v.([]byte)
+		atomic.AddUint64(&ch.hits, 1)
+		e := v.(*entry)
+		return e.v, e.storedAt
+	}
+	atomic.AddUint64(&ch.misses, 1)
+	return nil, time.Time{}
+}
+
+func (ch *apiCache) Delete(ctx context.Context, id string) {
+	if v, ok := ch.Get(id); ok {
+		atomic.StoreInt32(&v.(*entry).deleted, 1)
+	}
+	ch.Cache.Delete(id)
+}
+
+func (ch *apiCache) Flush(ctx context.Context) {
+	ch.Cache.Flush()
+}
+
+func (ch *apiCache) Stats(ctx context.Context) Stats {
+	return Stats{
+		Hits:      atomic.LoadUint64(&ch.hits),
+		Misses:    atomic.LoadUint64(&ch.misses),
+		Evictions: atomic.LoadUint64(&ch.evictions),
+		Items:     ch.ItemCount(),
 	}
-	return nil
 }
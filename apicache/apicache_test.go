@@ -1,6 +1,7 @@
 package apicache
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -9,23 +10,88 @@ import (
 
 func TestCacheValue(t *testing.T) {
 	c := New(1)
-	c.SetValue("test_1", []byte(`{"message":"test"}`))
+	c.SetValue(context.Background(), "test_1", []byte(`{"message":"test"}`))
 
-	v := c.GetValue("test_1")
+	v, storedAt := c.GetValue(context.Background(), "test_1")
 	if v == nil {
 		t.Errorf("Got: nil. Expected: %s", v)
 	}
+
+	if time.Since(storedAt) > time.Second {
+		t.Errorf("Unexpected stored time. Got: %v", storedAt)
+	}
 }
 
 func TestCacheExpiration(t *testing.T) {
-	mockCache := &apiCache{cache.New(500*time.Millisecond, 1*time.Second)}
+	mockCache := newAPICache(cache.New(500*time.Millisecond, 1*time.Second))
 
-	mockCache.SetValue("test_1", []byte(`{"message":"test"}`))
+	mockCache.SetValue(context.Background(), "test_1", []byte(`{"message":"test"}`))
 
 	time.Sleep(1 * time.Second)
 
-	v := mockCache.GetValue("test_1")
+	v, _ := mockCache.GetValue(context.Background(), "test_1")
 	if v != nil {
 		t.Errorf("Got: %s. Expected: nil", v)
 	}
 }
+
+func TestCacheStats(t *testing.T) {
+	c := newAPICache(cache.New(500*time.Millisecond, 100*time.Millisecond))
+	ctx := context.Background()
+
+	c.SetValue(ctx, "test_1", []byte(`{"message":"test"}`))
+	c.SetValue(ctx, "test_2", []byte(`{"message":"test"}`))
+	c.SetValue(ctx, "test_3", []byte(`{"message":"test"}`))
+	c.GetValue(ctx, "test_1")
+	c.GetValue(ctx, "test_4")
+
+	tests := []struct {
+		name     string
+		stats    Stats
+		expected Stats
+	}{
+		{"Stored values", c.Stats(ctx), Stats{Hits: 1, Misses: 1, Items: 3}},
+		{"Deleted value", func() Stats {
+			c.Delete(ctx, "test_1")
+			return c.Stats(ctx)
+		}(), Stats{Hits: 1, Misses: 1, Items: 2}},
+		{"Expired values", func() Stats {
+			time.Sleep(800 * time.Millisecond)
+			return c.Stats(ctx)
+		}(), Stats{Hits: 1, Misses: 1, Evictions: 2, Items: 0}},
+		{"Flushed values", func() Stats {
+			c.SetValue(ctx, "test_1", []byte(`{"message":"test"}`))
+			c.Flush(ctx)
+			return c.Stats(ctx)
+		}(), Stats{Hits: 1, Misses: 1, Evictions: 2, Items: 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.stats != test.expected {
+				t.Errorf("Error in test:  %s. Got: %+v, Expected: %+v", test.name, test.stats, test.expected)
+			}
+		})
+	}
+}
+
+func TestHitRatio(t *testing.T) {
+	tests := []struct {
+		name     string
+		stats    Stats
+		expected float64
+	}{
+		{"No lookups", Stats{}, 0},
+		{"Only hits", Stats{Hits: 3}, 1},
+		{"Only misses", Stats{Misses: 3}, 0},
+		{"Hits and misses", Stats{Hits: 3, Misses: 1}, 0.75},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ratio := test.stats.HitRatio(); ratio != test.expected {
+				t.Errorf("Error in test:  %s. Got: %v, Expected: %v", test.name, ratio, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package apicache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/garciacer87/weatherAPI/logging"
+)
+
+const (
+	//redisPoolSize is the maximum number of idle connections kept to Redis
+	redisPoolSize = 10
+	//redisRetryInterval is how long the cache keeps using memory after Redis becomes unreachable
+	redisRetryInterval = 5 * time.Second
+	//redisScanCount is the number of keys asked to Redis on every SCAN iteration
+	redisScanCount = "100"
+	//redisItemsInterval is how long the number of items is reused before scanning the keys again, so frequent
+	//Stats calls, like metrics scrapes, do not walk the whole keyspace every time
+	redisItemsInterval = 30 * time.Second
+)
+
+//redisCache stores responses in Redis, so every replica shares the same entries.
+//When Redis is unreachable, it falls back to an in-memory cache
+type redisCache struct {
+	pool     *respPool
+	prefix   string
+	ttl      time.Duration
+	fallback Cache
+	logger   *logging.Logger
+
+	mu        sync.Mutex
+	downUntil time.Time
+	items     int
+	itemsAt   time.Time
+
+	hits   uint64
+	misses uint64
+}
+
+//NewRedis returns a new cache backed by the Redis server at addr. Keys are prefixed with prefix
+//and expire after d minutes. Connection errors are logged to logger
+func NewRedis(addr, password, prefix string, d int, logger *logging.Logger) Cache {
+	return &redisCache{
+		pool:     newRESPPool(addr, password, redisPoolSize),
+		prefix:   prefix,
+		ttl:      time.Duration(d) * time.Minute,
+		fallback: New(d),
+		logger:   logger.With("component", "redis"),
+	}
+}
+
+func (rc *redisCache) SetValue(ctx context.Context, id string, v []byte) {
+	if rc.available() {
+		ttl := strconv.FormatInt(rc.ttl.Milliseconds(), 10)
+		_, err := rc.pool.do(ctx, "SET", rc.prefix+id, encodeEntry(v, time.Now()), "PX", ttl)
+		if err == nil {
+			return
+		}
+		rc.markDown(err)
+	}
+
+	rc.fallback.SetValue(ctx, id, v)
+}
+
+func (rc *redisCache) GetValue(ctx context.Context, id string) ([]byte, time.Time) {
+	v, storedAt := rc.get(ctx, id)
+	if v != nil {
+		atomic.AddUint64(&rc.hits, 1)
+	} else {
+		atomic.AddUint64(&rc.misses, 1)
+	}
+
+	return v, storedAt
+}
+
+func (rc *redisCache) get(ctx context.Context, id string) ([]byte, time.Time) {
+	if rc.available() {
+		reply, err := rc.pool.do(ctx, "GET", rc.prefix+id)
+		if err == nil {
+			v, _ := reply.([]byte)
+			return decodeEntry(v)
+		}
+		rc.markDown(err)
+	}
+
+	return rc.fallback.GetValue(ctx, id)
+}
+
+//Delete removes the value from Redis and from the memory fallback, which may hold values stored during an outage
+func (rc *redisCache) Delete(ctx context.Context, id string) {
+	if rc.available() {
+		if _, err := rc.pool.do(ctx, "DEL", rc.prefix+id); err != nil {
+			rc.markDown(err)
+		}
+	}
+
+	rc.resetItems()
+	rc.fallback.Delete(ctx, id)
+}
+
+//Flush removes every key under the cache prefix, leaving other keys in Redis untouched
+func (rc *redisCache) Flush(ctx context.Context) {
+	if rc.available() {
+		var batches [][]string
+		err := rc.scan(ctx, func(keys []string) error {
+			batches = append(batches, keys)
+			return nil
+		})
+		for i := 0; err == nil && i < len(batches); i++ {
+			_, err = rc.pool.do(ctx, append([]string{"DEL"}, batches[i]...)...)
+		}
+		if err != nil {
+			rc.markDown(err)
+		}
+	}
+
+	rc.resetItems()
+	rc.fallback.Flush(ctx)
+}
+
+//Stats counts the lookups made by this replica. Items are the keys under the cache prefix, shared by every replica,
+//counted at most once every redisItemsInterval. Redis expires keys on its own, so evictions are not tracked
+func (rc *redisCache) Stats(ctx context.Context) Stats {
+	stats := Stats{
+		Hits:   atomic.LoadUint64(&rc.hits),
+		Misses: atomic.LoadUint64(&rc.misses),
+	}
+
+	if rc.available() {
+		items, err := rc.countItems(ctx)
+		if err == nil {
+			stats.Items = items
+			return stats
+		}
+		rc.markDown(err)
+	}
+
+	stats.Items = rc.fallback.Stats(ctx).Items
+	return stats
+}
+
+//countItems returns the number of keys under the cache prefix, scanning them again once the last count is
+//older than redisItemsInterval
+func (rc *redisCache) countItems(ctx context.Context) (int, error) {
+	rc.mu.Lock()
+	if time.Since(rc.itemsAt) < redisItemsInterval {
+		defer rc.mu.Unlock()
+		return rc.items, nil
+	}
+	rc.mu.Unlock()
+
+	items := 0
+	err := rc.scan(ctx, func(keys []string) error {
+		items += len(keys)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.items, rc.itemsAt = items, time.Now()
+	return items, nil
+}
+
+//resetItems makes the next Stats call count the keys again
+func (rc *redisCache) resetItems() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.itemsAt = time.Time{}
+}
+
+//scan calls fn with every batch of keys under the cache prefix, until Redis returns the last batch
+func (rc *redisCache) scan(ctx context.Context, fn func(keys []string) error) error {
+	cursor := "0"
+	for {
+		reply, err := rc.pool.do(ctx, "SCAN", cursor, "MATCH", rc.prefix+"*", "COUNT", redisScanCount)
+		if err != nil {
+			return err
+		}
+
+		items, _ := reply.([]interface{})
+		if len(items) != 2 {
+			return errors.New("malformed SCAN reply")
+		}
+		next, _ := items[0].([]byte)
+		found, _ := items[1].([]interface{})
+
+		var keys []string
+		for _, key := range found {
+			if k, ok := key.([]byte); ok {
+				keys = append(keys, string(k))
+			}
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+//Ping checks Redis is reachable. Entries are kept in memory while it is not
+func (rc *redisCache) Ping(ctx context.Context) error {
+	_, err := rc.pool.do(ctx, "PING")
+	return err
+}
+
+//Close closes the idle connections to Redis
+func (rc *redisCache) Close() error {
+	rc.pool.close()
+	return nil
+}
+
+//encodeEntry prepends the stored time (unix nanoseconds) to the value, like "1611558107000000000:{...}"
+func encodeEntry(v []byte, storedAt time.Time) string {
+	return strconv.FormatInt(storedAt.UnixNano(), 10) + ":" + string(v)
+}
+
+//decodeEntry splits an encoded entry. Malformed entries are treated as missing
+func decodeEntry(data []byte) ([]byte, time.Time) {
+	i := bytes.IndexByte(data, ':')
+	if i < 0 {
+		return nil, time.Time{}
+	}
+
+	ns, err := strconv.ParseInt(string(data[:i]), 10, 64)
+	if err != nil {
+		return nil, time.Time{}
+	}
+
+	return data[i+1:], time.Unix(0, ns)
+}
+
+//available reports whether Redis should be tried, or the cache is still falling back to memory
+func (rc *redisCache) available() bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return time.Now().After(rc.downUntil)
+}
+
+func (rc *redisCache) markDown(err error) {
+	if _, ok := err.(redisError); ok {
+		return
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if time.Now().After(rc.downUntil) {
+		rc.logger.Warn("Redis unreachable, using memory cache", "retry_in", redisRetryInterval, "error", err)
+	}
+	rc.downUntil = time.Now().Add(redisRetryInterval)
+}
@@ -0,0 +1,368 @@
+package apicache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//fakeRedis is an in-process RESP stand-in supporting the commands used by the cache
+type fakeRedis struct {
+	net.Listener
+	mu       sync.Mutex
+	password string
+	values   map[string]string
+	expires  map[string]time.Time
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot start fake redis: %v", err)
+	}
+
+	fr := &fakeRedis{l, sync.Mutex{}, password, make(map[string]string), make(map[string]time.Time)}
+	go fr.serve()
+
+	return fr
+}
+
+func (fr *fakeRedis) serve() {
+	for {
+		conn, err := fr.Accept()
+		if err != nil {
+			return
+		}
+		go fr.handle(conn)
+	}
+}
+
+func (fr *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authenticated := fr.password == ""
+
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+
+		var args []string
+		for _, arg := range reply.([]interface{}) {
+			args = append(args, string(arg.([]byte)))
+		}
+
+		if strings.ToUpper(args[0]) == "AUTH" {
+			authenticated = len(args) == 2 && args[1] == fr.password
+			if !authenticated {
+				fmt.Fprint(conn, "-ERR invalid password\r\n")
+				continue
+			}
+			fmt.Fprint(conn, "+OK\r\n")
+			continue
+		}
+
+		if !authenticated {
+			fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+
+		fmt.Fprint(conn, fr.exec(args))
+	}
+}
+
+func (fr *fakeRedis) exec(args []string) string {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "SET":
+		fr.values[args[1]] = args[2]
+		delete(fr.expires, args[1])
+		if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+			ms, _ := strconv.Atoi(args[4])
+			fr.expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return "+OK\r\n"
+	case "GET":
+		v, ok := fr.values[args[1]]
+		if exp, has := fr.expires[args[1]]; !ok || (has && time.Now().After(exp)) {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, ok := fr.values[key]; ok {
+				deleted++
+			}
+			delete(fr.values, key)
+			delete(fr.expires, key)
+		}
+		return fmt.Sprintf(":%d\r\n", deleted)
+	case "SCAN":
+		return fr.scan(args)
+	}
+
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+}
+
+//scan pages through the keys in order, the cursor being the index of the next key.
+//Only "MATCH prefix*" patterns are supported
+func (fr *fakeRedis) scan(args []string) string {
+	cursor, _ := strconv.Atoi(args[1])
+	pattern, count := "*", 10
+	for i := 2; i+1 < len(args); i += 2 {
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, _ = strconv.Atoi(args[i+1])
+		}
+	}
+
+	var keys []string
+	for key := range fr.values {
+		if strings.HasPrefix(key, strings.TrimSuffix(pattern, "*")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	end := cursor + count
+	next := strconv.Itoa(end)
+	if end >= len(keys) {
+		end, next = len(keys), "0"
+	}
+	if cursor > end {
+		cursor = end
+	}
+
+	reply := fmt.Sprintf("*2\r\n$%d\r\n%s\r\n*%d\r\n", len(next), next, end-cursor)
+	for _, key := range keys[cursor:end] {
+		reply += fmt.Sprintf("$%d\r\n%s\r\n", len(key), key)
+	}
+	return reply
+}
+
+func (fr *fakeRedis) set(key, v string) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	fr.values[key] = v
+}
+
+func (fr *fakeRedis) get(key string) (string, bool) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	v, ok := fr.values[key]
+	return v, ok
+}
+
+func TestRedisCacheValue(t *testing.T) {
+	fr := newFakeRedis(t, "secret")
+	defer fr.Close()
+
+	c := NewRedis(fr.Addr().String(), "secret", "weatherapi:", 1, nil)
+	c.SetValue(context.Background(), "test_1", []byte(`{"message":"test"}`))
+
+	v, storedAt := c.GetValue(context.Background(), "test_1")
+	if string(v) != `{"message":"test"}` {
+		t.Errorf("Got: %s. Expected: %s", v, `{"message":"test"}`)
+	}
+
+	if time.Since(storedAt) > time.Second {
+		t.Errorf("Unexpected stored time. Got: %v", storedAt)
+	}
+
+	if _, ok := fr.get("weatherapi:test_1"); !ok {
+		t.Errorf("Expected prefixed key in redis: %s", "weatherapi:test_1")
+	}
+
+	if v, _ := c.GetValue(context.Background(), "test_2"); v != nil {
+		t.Errorf("Got: %s. Expected: nil", v)
+	}
+}
+
+func TestRedisCacheExpiration(t *testing.T) {
+	fr := newFakeRedis(t, "")
+	defer fr.Close()
+
+	c := &redisCache{
+		pool:     newRESPPool(fr.Addr().String(), "", redisPoolSize),
+		prefix:   "weatherapi:",
+		ttl:      100 * time.Millisecond,
+		fallback: New(1),
+	}
+	c.SetValue(context.Background(), "test_1", []byte(`{"message":"test"}`))
+
+	time.Sleep(200 * time.Millisecond)
+
+	if v, _ := c.GetValue(context.Background(), "test_1"); v != nil {
+		t.Errorf("Got: %s. Expected: nil", v)
+	}
+}
+
+func TestRedisCacheFallback(t *testing.T) {
+	fr := newFakeRedis(t, "")
+	addr := fr.Addr().String()
+	fr.Close()
+
+	c := NewRedis(addr, "", "weatherapi:", 1, nil)
+	c.SetValue(context.Background(), "test_1", []byte(`{"message":"test"}`))
+
+	v, _ := c.GetValue(context.Background(), "test_1")
+	if string(v) != `{"message":"test"}` {
+		t.Errorf("Got: %s. Expected: %s", v, `{"message":"test"}`)
+	}
+
+	if c.(*redisCache).available() {
+		t.Errorf("Expected redis to be marked as unavailable")
+	}
+}
+
+func TestRedisCachePing(t *testing.T) {
+	fr := newFakeRedis(t, "")
+	c := NewRedis(fr.Addr().String(), "", "weatherapi:", 1, nil).(Pinger)
+
+	if err := c.Ping(context.Background()); err != nil {
+		t.Errorf("Unexpected error pinging redis: %v", err)
+	}
+
+	fr.Close()
+	c.(*redisCache).Close()
+	if err := c.Ping(context.Background()); err == nil {
+		t.Errorf("Expected error pinging a closed redis")
+	}
+}
+
+func TestRedisCachePurge(t *testing.T) {
+	fr := newFakeRedis(t, "")
+	defer fr.Close()
+	fr.set("other:test_1", "kept")
+
+	c := NewRedis(fr.Addr().String(), "", "weatherapi:", 1, nil)
+	for i := 0; i < 250; i++ {
+		c.SetValue(context.Background(), fmt.Sprintf("test_%d", i), []byte(`{"message":"test"}`))
+	}
+	c.GetValue(context.Background(), "test_1")
+	c.GetValue(context.Background(), "missing")
+
+	stats := c.Stats(context.Background())
+	expected := Stats{Hits: 1, Misses: 1, Items: 250}
+	if stats != expected {
+		t.Errorf("Error in stats. Got: %+v, Expected: %+v", stats, expected)
+	}
+
+	c.Delete(context.Background(), "test_1")
+	if _, ok := fr.get("weatherapi:test_1"); ok {
+		t.Errorf("Expected key to be deleted: %s", "weatherapi:test_1")
+	}
+
+	c.Flush(context.Background())
+	if items := c.Stats(context.Background()).Items; items != 0 {
+		t.Errorf("Error in items after flush. Got: %d, Expected: %d", items, 0)
+	}
+
+	if _, ok := fr.get("other:test_1"); !ok {
+		t.Errorf("Flush must not delete keys outside the prefix")
+	}
+}
+
+func TestRedisCacheItemsReused(t *testing.T) {
+	fr := newFakeRedis(t, "")
+	defer fr.Close()
+
+	c := NewRedis(fr.Addr().String(), "", "weatherapi:cache:", 1, nil)
+	c.SetValue(context.Background(), "test_1", []byte(`{"message":"test"}`))
+	if items := c.Stats(context.Background()).Items; items != 1 {
+		t.Errorf("Error in items. Got: %d, Expected: %d", items, 1)
+	}
+
+	//keys stored after the last count are not seen until redisItemsInterval passes
+	c.SetValue(context.Background(), "test_2", []byte(`{"message":"test"}`))
+	if items := c.Stats(context.Background()).Items; items != 1 {
+		t.Errorf("Error in reused items. Got: %d, Expected: %d", items, 1)
+	}
+
+	c.Delete(context.Background(), "test_1")
+	if items := c.Stats(context.Background()).Items; items != 1 {
+		t.Errorf("Error in items after delete. Got: %d, Expected: %d", items, 1)
+	}
+}
+
+func TestRedisCachePrefixes(t *testing.T) {
+	fr := newFakeRedis(t, "")
+	defer fr.Close()
+
+	cache := NewRedis(fr.Addr().String(), "", "weatherapi:cache:", 1, nil)
+	lastKnownGood := NewRedis(fr.Addr().String(), "", "weatherapi:lkg:", 1, nil)
+	cache.SetValue(context.Background(), "paris_fr", []byte(`{"message":"test"}`))
+	lastKnownGood.SetValue(context.Background(), "paris_fr", []byte(`{"message":"test"}`))
+
+	if items := cache.Stats(context.Background()).Items; items != 1 {
+		t.Errorf("Error in items. Got: %d, Expected: %d", items, 1)
+	}
+
+	cache.Flush(context.Background())
+	if v, _ := lastKnownGood.GetValue(context.Background(), "paris_fr"); v == nil {
+		t.Errorf("Flush must not delete the keys of another cache")
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Simple string", "+OK\r\n", "OK"},
+		{"Integer", ":42\r\n", "42"},
+		{"Bulk string", "$4\r\ntest\r\n", "[116 101 115 116]"},
+		{"Null bulk string", "$-1\r\n", "<nil>"},
+		{"Array", "*2\r\n$1\r\na\r\n:1\r\n", "[[97] 1]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reply, err := readReply(bufio.NewReader(strings.NewReader(test.input)))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if fmt.Sprint(reply) != test.expected {
+				t.Errorf("Error in test:  %s. Got: %v, Expected: %s", test.name, reply, test.expected)
+			}
+		})
+	}
+
+	_, err := readReply(bufio.NewReader(strings.NewReader("-ERR wrong\r\n")))
+	if _, ok := err.(redisError); !ok {
+		t.Errorf("Expected redis error. Got: %v", err)
+	}
+}
+
+func TestEncodeEntry(t *testing.T) {
+	storedAt := time.Unix(1611558107, 0)
+
+	v, decodedAt := decodeEntry([]byte(encodeEntry([]byte(`{"a":"b:c"}`), storedAt)))
+	if string(v) != `{"a":"b:c"}` {
+		t.Errorf("Got: %s. Expected: %s", v, `{"a":"b:c"}`)
+	}
+
+	if !decodedAt.Equal(storedAt) {
+		t.Errorf("Got: %v. Expected: %v", decodedAt, storedAt)
+	}
+
+	if v, _ := decodeEntry([]byte("malformed")); v != nil {
+		t.Errorf("Got: %s. Expected: nil", v)
+	}
+}
@@ -0,0 +1,170 @@
+package apicache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+//defaultOpTimeout bounds every Redis command when the context has no deadline
+const defaultOpTimeout = time.Second
+
+//redisError is an error reply sent by Redis, like "-ERR unknown command"
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+//respConn is a single connection speaking the Redis serialization protocol (RESP)
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func dialRESP(ctx context.Context, addr, password string) (*respConn, error) {
+	var d net.Dialer
+	ctx, cancel := context.WithTimeout(ctx, defaultOpTimeout)
+	defer cancel()
+
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &respConn{conn, bufio.NewReader(conn), bufio.NewWriter(conn)}
+	if password != "" {
+		if _, err := c.do(ctx, "AUTH", password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+//do sends a command and reads its reply. Replies are returned as string (simple strings), int64 (integers),
+//[]byte (bulk strings), []interface{} (arrays) or nil. Error replies are returned as redisError
+func (c *respConn) do(ctx context.Context, args ...string) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultOpTimeout)
+	}
+	c.conn.SetDeadline(deadline)
+
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return readReply(c.r)
+}
+
+func (c *respConn) close() error {
+	return c.conn.Close()
+}
+
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("malformed RESP reply")
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, redisError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:size], nil
+	case '*':
+		size, err := strconv.Atoi(payload)
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, size)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				if _, ok := err.(redisError); !ok {
+					return nil, err
+				}
+			}
+		}
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("unknown RESP reply type: %q", kind)
+}
+
+//respPool keeps idle connections to a Redis server
+type respPool struct {
+	addr     string
+	password string
+	idle     chan *respConn
+}
+
+func newRESPPool(addr, password string, size int) *respPool {
+	return &respPool{addr, password, make(chan *respConn, size)}
+}
+
+//do runs a command on an idle connection, dialing a new one when none is available.
+//Connections failing with a network error are discarded
+func (p *respPool) do(ctx context.Context, args ...string) (interface{}, error) {
+	var c *respConn
+	select {
+	case c = <-p.idle:
+	default:
+		var err error
+		if c, err = dialRESP(ctx, p.addr, p.password); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := c.do(ctx, args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		c.close()
+		return nil, err
+	}
+
+	select {
+	case p.idle <- c:
+	default:
+		c.close()
+	}
+
+	return reply, err
+}
+
+func (p *respPool) close() {
+	for {
+		select {
+		case c := <-p.idle:
+			c.close()
+		default:
+			return
+		}
+	}
+}
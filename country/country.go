@@ -0,0 +1,65 @@
+package country
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+//Country is an ISO 3166-1 country
+type Country struct {
+	//Alpha2 is the two letter code, like "FR"
+	Alpha2 string
+	//Alpha3 is the three letter code, like "FRA"
+	Alpha3 string
+	//Name is the English short name, like "France"
+	Name string
+	//Aliases are other English names of the country
+	Aliases []string
+}
+
+//index holds every country by folded alpha-2 code, alpha-3 code, name and alias
+var index = make(map[string]Country, 4*len(countries))
+
+func init() {
+	for _, c := range countries {
+		index[fold(c.Alpha2)] = c
+		index[fold(c.Alpha3)] = c
+		index[fold(c.Name)] = c
+		for _, alias := range c.Aliases {
+			index[fold(alias)] = c
+		}
+	}
+}
+
+//Lookup returns the country identified by s: an alpha-2 code, an alpha-3 code, or an English name.
+//Case, accents and repeated spaces are ignored, so "fr", "FRA", "france" and "Cote d'Ivoire" are found
+func Lookup(s string) (Country, bool) {
+	c, ok := index[fold(s)]
+	return c, ok
+}
+
+//Code returns the lowercase alpha-2 code of the country identified by s, like "fr" for "France", as used in
+//OpenWeather queries and cache keys. Unknown countries are returned unchanged
+func Code(s string) string {
+	if c, ok := Lookup(s); ok {
+		return strings.ToLower(c.Alpha2)
+	}
+	return s
+}
+
+//fold lowercases s, removes its accents, unifies apostrophes and collapses its spaces
+func fold(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+		case r == '’':
+			b.WriteRune('\'')
+		default:
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
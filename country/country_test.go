@@ -0,0 +1,82 @@
+package country
+
+import "testing"
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name     string
+		country  string
+		expected string
+	}{
+		{"Lowercase alpha-2", "fr", "FR"},
+		{"Uppercase alpha-2", "FR", "FR"},
+		{"Alpha-3", "FRA", "FR"},
+		{"Lowercase alpha-3", "usa", "US"},
+		{"Name", "France", "FR"},
+		{"Lowercase name", "united kingdom", "GB"},
+		{"Name with accents", "Côte d'Ivoire", "CI"},
+		{"Name without accents", "Cote d'Ivoire", "CI"},
+		{"Typographic apostrophe", "Côte d’Ivoire", "CI"},
+		{"Repeated spaces", "  United   States ", "US"},
+		{"Common name", "Bolivia", "BO"},
+		{"Official name", "United States of America", "US"},
+		{"Former name", "Turkey", "TR"},
+		{"Current name", "Türkiye", "TR"},
+		{"Widely used code", "UK", "GB"},
+		{"Unknown alpha-2", "zz", ""},
+		{"Unknown alpha-3", "ZZZ", ""},
+		{"Unknown name", "Atlantis", ""},
+		{"Empty", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, ok := Lookup(test.country)
+			if ok != (test.expected != "") || c.Alpha2 != test.expected {
+				t.Errorf("Error in test:  %s. Got: %q, Expected: %q", test.name, c.Alpha2, test.expected)
+			}
+		})
+	}
+}
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		country  string
+		expected string
+	}{
+		{"Alpha-2", "FR", "fr"},
+		{"Alpha-3", "CHL", "cl"},
+		{"Name", "Chile", "cl"},
+		{"Unknown", "zz", "zz"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code := Code(test.country); code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %q, Expected: %q", test.name, code, test.expected)
+			}
+		})
+	}
+}
+
+func TestCountries(t *testing.T) {
+	if len(countries) != 249 {
+		t.Errorf("Unexpected number of countries. Got: %d, Expected: %d", len(countries), 249)
+	}
+
+	//every code and name must identify a single country
+	owners := make(map[string]string)
+	for _, c := range countries {
+		if len(c.Alpha2) != 2 || len(c.Alpha3) != 3 || c.Name == "" {
+			t.Errorf("Malformed country: %+v", c)
+		}
+
+		for _, key := range append([]string{c.Alpha2, c.Alpha3, c.Name}, c.Aliases...) {
+			if owner, ok := owners[fold(key)]; ok && owner != c.Alpha2 {
+				t.Errorf("%q identifies both %s and %s", key, owner, c.Alpha2)
+			}
+			owners[fold(key)] = c.Alpha2
+		}
+	}
+}
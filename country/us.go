@@ -0,0 +1,32 @@
+package country
+
+import "strings"
+
+//usStates are the ISO 3166-2:US codes of the 50 states and the District of Columbia, without the "US-" prefix
+var usStates = map[string]string{
+	"AL": "Alabama", "AK": "Alaska", "AZ": "Arizona", "AR": "Arkansas", "CA": "California",
+	"CO": "Colorado", "CT": "Connecticut", "DE": "Delaware", "DC": "District of Columbia", "FL": "Florida",
+	"GA": "Georgia", "HI": "Hawaii", "ID": "Idaho", "IL": "Illinois", "IN": "Indiana",
+	"IA": "Iowa", "KS": "Kansas", "KY": "Kentucky", "LA": "Louisiana", "ME": "Maine",
+	"MD": "Maryland", "MA": "Massachusetts", "MI": "Michigan", "MN": "Minnesota", "MS": "Mississippi",
+	"MO": "Missouri", "MT": "Montana", "NE": "Nebraska", "NV": "Nevada", "NH": "New Hampshire",
+	"NJ": "New Jersey", "NM": "New Mexico", "NY": "New York", "NC": "North Carolina", "ND": "North Dakota",
+	"OH": "Ohio", "OK": "Oklahoma", "OR": "Oregon", "PA": "Pennsylvania", "RI": "Rhode Island",
+	"SC": "South Carolina", "SD": "South Dakota", "TN": "Tennessee", "TX": "Texas", "UT": "Utah",
+	"VT": "Vermont", "VA": "Virginia", "WA": "Washington", "WV": "West Virginia", "WI": "Wisconsin",
+	"WY": "Wyoming",
+}
+
+//USState returns the uppercase code of the US state identified by s, a two letter code in any case like "il" or
+//"IL", and whether it exists
+func USState(s string) (string, bool) {
+	code := strings.ToUpper(strings.TrimSpace(s))
+	_, ok := usStates[code]
+	return code, ok
+}
+
+//IsUS reports whether s identifies the United States, like "us", "USA" or "United States"
+func IsUS(s string) bool {
+	c, ok := Lookup(s)
+	return ok && c.Alpha2 == "US"
+}
@@ -0,0 +1,55 @@
+package country
+
+import "testing"
+
+func TestUSState(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    string
+		expected string
+		ok       bool
+	}{
+		{"Uppercase", "IL", "IL", true},
+		{"Lowercase", "il", "IL", true},
+		{"District of Columbia", "dc", "DC", true},
+		{"Territory", "PR", "PR", false},
+		{"Name", "Illinois", "ILLINOIS", false},
+		{"Unknown", "ZZ", "ZZ", false},
+		{"Empty", "", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, ok := USState(test.state)
+			if code != test.expected || ok != test.ok {
+				t.Errorf("Error in test:  %s. Got: %q %t, Expected: %q %t", test.name, code, ok, test.expected, test.ok)
+			}
+		})
+	}
+
+	if len(usStates) != 51 {
+		t.Errorf("Unexpected number of states. Got: %d, Expected: %d", len(usStates), 51)
+	}
+}
+
+func TestIsUS(t *testing.T) {
+	tests := []struct {
+		name     string
+		country  string
+		expected bool
+	}{
+		{"Alpha-2", "us", true},
+		{"Alpha-3", "USA", true},
+		{"Name", "United States", true},
+		{"Other country", "fr", false},
+		{"Unknown", "zz", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IsUS(test.country); got != test.expected {
+				t.Errorf("Error in test:  %s. Got: %t, Expected: %t", test.name, got, test.expected)
+			}
+		})
+	}
+}
@@ -8,4 +8,5 @@ require (
 	github.com/jarcoal/httpmock v1.0.7
 	github.com/joho/godotenv v1.3.0
 	github.com/patrickmn/go-cache v2.1.0+incompatible
+	golang.org/x/text v0.3.6
 )
@@ -0,0 +1,157 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garciacer87/weatherAPI/requestid"
+)
+
+//Level is the severity of a log entry
+type Level int
+
+//Levels, from the most to the least verbose
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levels = map[Level]string{Debug: "debug", Info: "info", Warn: "warn", Error: "error"}
+
+func (l Level) String() string {
+	return levels[l]
+}
+
+//ParseLevel returns the level named s, like "debug" or "WARN"
+func ParseLevel(s string) (Level, bool) {
+	for level, name := range levels {
+		if strings.EqualFold(s, name) {
+			return level, true
+		}
+	}
+	return Info, false
+}
+
+//Logger writes entries as JSON lines, like {"time":"...","level":"info","msg":"...","city":"Paris"}.
+//A nil Logger discards every entry, so it can be left unset
+type Logger struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	level  Level
+	fields []byte
+}
+
+//New returns a logger writing entries of level or above to w
+func New(w io.Writer, level Level) *Logger {
+	return &Logger{mu: &sync.Mutex{}, w: w, level: level}
+}
+
+//With returns a logger adding the key value pairs kv to every entry
+func (l *Logger) With(kv ...interface{}) *Logger {
+	if l == nil {
+		return nil
+	}
+
+	child := *l
+	child.fields = appendFields(append([]byte(nil), l.fields...), kv)
+	return &child
+}
+
+//WithContext returns a logger adding the request ID carried by ctx, if any, to every entry
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	if id := requestid.FromContext(ctx); id != "" {
+		return l.With("request_id", id)
+	}
+	return l
+}
+
+//Enabled reports whether entries of level are written
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level >= l.level
+}
+
+//Debug writes an entry with the key value pairs kv, like Debug("msg", "city", "Paris")
+func (l *Logger) Debug(msg string, kv ...interface{}) {
+	l.log(Debug, msg, kv)
+}
+
+//Info writes an entry with the key value pairs kv
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	l.log(Info, msg, kv)
+}
+
+//Warn writes an entry with the key value pairs kv
+func (l *Logger) Warn(msg string, kv ...interface{}) {
+	l.log(Warn, msg, kv)
+}
+
+//Error writes an entry with the key value pairs kv
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	l.log(Error, msg, kv)
+}
+
+//Log writes an entry of level with the key value pairs kv
+func (l *Logger) Log(level Level, msg string, kv ...interface{}) {
+	l.log(level, msg, kv)
+}
+
+func (l *Logger) log(level Level, msg string, kv []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	var b bytes.Buffer
+	b.WriteString(`{"time":`)
+	b.Write(marshal(time.Now().UTC().Format(time.RFC3339Nano)))
+	b.WriteString(`,"level":`)
+	b.Write(marshal(level.String()))
+	b.WriteString(`,"msg":`)
+	b.Write(marshal(msg))
+	b.Write(l.fields)
+	b.Write(appendFields(nil, kv))
+	b.WriteString("}\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(b.Bytes())
+}
+
+//appendFields appends kv as JSON object members. A key without value is logged with a null value
+func appendFields(b []byte, kv []interface{}) []byte {
+	for i := 0; i < len(kv); i += 2 {
+		var v interface{}
+		if i+1 < len(kv) {
+			v = kv[i+1]
+		}
+
+		b = append(b, ',')
+		b = append(b, marshal(fmt.Sprint(kv[i]))...)
+		b = append(b, ':')
+		b = append(b, marshal(v)...)
+	}
+	return b
+}
+
+//marshal encodes v as JSON. Errors are encoded as their message, and values that cannot be encoded as strings
+func marshal(v interface{}) []byte {
+	switch value := v.(type) {
+	case error:
+		v = value.Error()
+	case time.Duration:
+		v = value.String()
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	return data
+}
@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garciacer87/weatherAPI/requestid"
+)
+
+func TestLogger(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b, Info).With("component", "test")
+
+	l.Debug("hidden")
+	l.Info("request served", "city", "Paris", "status", 200, "latency", 1500*time.Millisecond)
+	l.Error("upstream failed", "error", errors.New("connection refused"), "orphan")
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Error in lines. Got: %d, Expected: %d", len(lines), 2)
+	}
+
+	tests := []struct {
+		name     string
+		line     string
+		expected map[string]interface{}
+	}{
+		{"Info entry", lines[0], map[string]interface{}{
+			"level": "info", "msg": "request served", "component": "test", "city": "Paris", "status": float64(200), "latency": "1.5s",
+		}},
+		{"Error entry", lines[1], map[string]interface{}{
+			"level": "error", "msg": "upstream failed", "component": "test", "error": "connection refused", "orphan": nil,
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var entry map[string]interface{}
+			if err := json.Unmarshal([]byte(test.line), &entry); err != nil {
+				t.Fatalf("Error in test:  %s. Invalid JSON: %s", test.name, test.line)
+			}
+			if _, err := time.Parse(time.RFC3339Nano, entry["time"].(string)); err != nil {
+				t.Errorf("Error in test:  %s. Invalid time: %v", test.name, entry["time"])
+			}
+			for key, value := range test.expected {
+				if entry[key] != value {
+					t.Errorf("Error in test:  %s. Got %s: %v, Expected: %v", test.name, key, entry[key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b, Info)
+
+	l.WithContext(requestid.NewContext(context.Background(), "abc")).Info("with request ID")
+	l.WithContext(context.Background()).Info("without request ID")
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if !strings.Contains(lines[0], `"request_id":"abc"`) {
+		t.Errorf("Expected request ID in: %s", lines[0])
+	}
+	if strings.Contains(lines[1], "request_id") {
+		t.Errorf("Unexpected request ID in: %s", lines[1])
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	var l *Logger
+	l.With("city", "Paris").Error("discarded")
+
+	if l.Enabled(Error) {
+		t.Errorf("Nil logger must not be enabled")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected Level
+		ok       bool
+	}{
+		{"Debug", "debug", Debug, true},
+		{"Uppercase", "WARN", Warn, true},
+		{"Error", "error", Error, true},
+		{"Unknown", "verbose", Info, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			level, ok := ParseLevel(test.value)
+			if level != test.expected || ok != test.ok {
+				t.Errorf("Error in test:  %s. Got: %v %v, Expected: %v %v", test.name, level, ok, test.expected, test.ok)
+			}
+		})
+	}
+}
@@ -2,35 +2,62 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"os"
 
+	"github.com/garciacer87/weatherAPI/logging"
 	"github.com/garciacer87/weatherAPI/server"
+	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
 
+var logger *logging.Logger
+
 func init() {
 	godotenv.Load()
 
+	l := os.Getenv("LOG_LEVEL")
+	level, ok := logging.ParseLevel(l)
+	logger = logging.New(os.Stdout, level)
+	if l != "" && !ok {
+		logger.Warn("Unknown log level on LOG_LEVEL env. Using info", "level", l)
+	}
+
+	//gin debug messages are plain text, which would break the JSON lines written to stdout. GIN_MODE=debug restores them
+	if os.Getenv(gin.EnvGinMode) == "" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
 	if os.Getenv("OPENWEATHERMAP_HOST") == "" {
-		log.Fatal("Cannot init API. Missing environment var: OPENWEATHERMAP_HOST")
+		fatal("Cannot init API. Missing environment var: OPENWEATHERMAP_HOST")
 	}
 	if os.Getenv("OPENWEATHERMAP_APIKEY") == "" {
-		log.Fatal("Cannot init API. Missing environment var: OPENWEATHERMAP_APIKEY")
+		fatal("Cannot init API. Missing environment var: OPENWEATHERMAP_APIKEY")
+	}
+	if os.Getenv("CACHE_BACKEND") == "redis" && os.Getenv("REDIS_ADDR") == "" {
+		fatal("Cannot init API. Missing environment var: REDIS_ADDR")
 	}
 }
 
 func main() {
-	s := server.New()
+	s, err := server.New(logger)
+	if err != nil {
+		fatal("Cannot init API", "error", err)
+	}
 
 	port := os.Getenv("SERVER_PORT")
 	if port == "" {
-		log.Println("No port was found on SERVER_PORT env. Using default port 8080")
+		logger.Info("No port was found on SERVER_PORT env. Using default port 8080")
 		port = "8080"
 	}
 
-	err := s.Run(fmt.Sprintf(":%s", port))
+	err = s.ListenAndServe(fmt.Sprintf(":%s", port))
 	if err != nil {
-		log.Fatalf("Error trying to serve application: %v", err)
+		fatal("Error trying to serve application", "error", err)
 	}
 }
+
+//fatal logs an error and exits
+func fatal(msg string, kv ...interface{}) {
+	logger.Error(msg, kv...)
+	os.Exit(1)
+}
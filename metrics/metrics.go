@@ -0,0 +1,231 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//DefaultBuckets are the histogram buckets used for latencies, in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+//Default is the registry where the package level constructors register their metrics
+var Default = NewRegistry()
+
+//Registry holds metrics and writes them in the Prometheus text exposition format
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*vec
+}
+
+//NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+//Write writes every metric, in registration order, in the Prometheus text exposition format
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]*vec(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+func (r *Registry) register(m *vec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+//CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	*vec
+}
+
+//NewCounterVec returns a counter registered in r
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, "counter", nil, labels)}
+	r.register(c.vec)
+	return c
+}
+
+//Inc adds one to the counter with the given label values
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+//Add adds v to the counter with the given label values
+func (c *CounterVec) Add(v float64, values ...string) {
+	c.update(values, func(s *series) { s.value += v })
+}
+
+//GaugeVec is a value partitioned by labels, which can go up and down
+type GaugeVec struct {
+	*vec
+}
+
+//NewGaugeVec returns a gauge registered in r
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, "gauge", nil, labels)}
+	r.register(g.vec)
+	return g
+}
+
+//Set sets the gauge with the given label values
+func (g *GaugeVec) Set(v float64, values ...string) {
+	g.update(values, func(s *series) { s.value = v })
+}
+
+//HistogramVec counts observations in buckets, partitioned by labels
+type HistogramVec struct {
+	*vec
+}
+
+//NewHistogramVec returns a histogram registered in r. buckets are the upper bounds, in increasing order
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{newVec(name, help, "histogram", buckets, labels)}
+	r.register(h.vec)
+	return h
+}
+
+//Observe adds v to the histogram with the given label values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	h.update(values, func(s *series) {
+		for i, bound := range h.buckets {
+			if v <= bound {
+				s.buckets[i]++
+			}
+		}
+		s.sum += v
+		s.count++
+	})
+}
+
+//NewCounterVec returns a counter registered in the Default registry
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+//NewGaugeVec returns a gauge registered in the Default registry
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+//NewHistogramVec returns a histogram registered in the Default registry
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+//vec keeps a series per combination of label values
+type vec struct {
+	name    string
+	help    string
+	kind    string
+	buckets []float64
+	labels  []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values  []string
+	value   float64
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+func newVec(name, help, kind string, buckets []float64, labels []string) *vec {
+	return &vec{name: name, help: help, kind: kind, buckets: buckets, labels: labels, series: make(map[string]*series)}
+}
+
+//update runs fn on the series with the given label values, creating it when missing.
+//Missing label values are left empty, and extra ones are ignored
+func (v *vec) update(values []string, fn func(s *series)) {
+	values = append([]string(nil), values...)
+	for len(values) < len(v.labels) {
+		values = append(values, "")
+	}
+	values = values[:len(v.labels)]
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{values: values, buckets: make([]uint64, len(v.buckets))}
+		v.series[key] = s
+	}
+	fn(s)
+}
+
+//write writes the metric, sorting series by their label values so the output is stable
+func (v *vec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, escape(v.help, false))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.kind)
+
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := v.series[key]
+		if v.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", v.name, v.fmtLabels(s.values, ""), fmtFloat(s.value))
+			continue
+		}
+
+		for i, bound := range v.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, v.fmtLabels(s.values, fmtFloat(bound)), s.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, v.fmtLabels(s.values, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, v.fmtLabels(s.values, ""), fmtFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, v.fmtLabels(s.values, ""), s.count)
+	}
+}
+
+//fmtLabels formats label values like {route="/weather",status="200"}, adding the le label of histogram buckets when not empty
+func (v *vec) fmtLabels(values []string, le string) string {
+	var pairs []string
+	for i, label := range v.labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, label, escape(values[i], true)))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf(`le="%s"`, le))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+//escape escapes backslashes and line feeds, and double quotes in label values
+func escape(s string, quotes bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if quotes {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+	return s
+}
+
+func fmtFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
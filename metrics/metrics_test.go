@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests served.", "route", "status")
+	ratio := r.NewGaugeVec("hit_ratio", "Hit ratio.", "cache")
+	latency := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+
+	requests.Inc("/weather", "200")
+	requests.Inc("/weather", "200")
+	requests.Inc("/weather", "400")
+	ratio.Set(0.75, "cache")
+	latency.Observe(0.05, "/weather")
+	latency.Observe(0.5, "/weather")
+	latency.Observe(2, "/weather")
+
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="/weather",status="200"} 2
+requests_total{route="/weather",status="400"} 1
+# HELP hit_ratio Hit ratio.
+# TYPE hit_ratio gauge
+hit_ratio{cache="cache"} 0.75
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/weather",le="0.1"} 1
+latency_seconds_bucket{route="/weather",le="1"} 2
+latency_seconds_bucket{route="/weather",le="+Inf"} 3
+latency_seconds_sum{route="/weather"} 2.55
+latency_seconds_count{route="/weather"} 3
+`
+	if b.String() != expected {
+		t.Errorf("Got:\n%s\nExpected:\n%s", b.String(), expected)
+	}
+}
+
+func TestLabelValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []string
+		expected string
+	}{
+		{"Escaped value", []string{`a"b\c`}, `test_total{path="a\"b\\c"} 1`},
+		{"Missing value", nil, `test_total{path=""} 1`},
+		{"Extra value", []string{"a", "b"}, `test_total{path="a"} 1`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewRegistry()
+			r.NewCounterVec("test_total", "Test.", "path").Inc(test.values...)
+
+			var b strings.Builder
+			r.Write(&b)
+			if !strings.Contains(b.String(), test.expected+"\n") {
+				t.Errorf("Error in test:  %s. Got: %s, Expected: %s", test.name, b.String(), test.expected)
+			}
+		})
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests served.", "route")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			requests.Inc("/weather")
+		}()
+	}
+	wg.Wait()
+
+	var b strings.Builder
+	r.Write(&b)
+	if !strings.Contains(b.String(), `requests_total{route="/weather"} 100`) {
+		t.Errorf("Got: %s. Expected 100 requests", b.String())
+	}
+}
@@ -0,0 +1,202 @@
+package openweather
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/garciacer87/weatherAPI/metrics"
+)
+
+//budgetPressure is the share of a window budget below which the budget is under pressure
+const budgetPressure = 0.1
+
+var (
+	//ErrBudgetExhausted is returned when a call to OpenWeather API would exceed the call budget
+	ErrBudgetExhausted = errors.New("OpenWeather API call budget exhausted")
+
+	budgetRemaining = metrics.NewGaugeVec("weatherapi_upstream_budget_remaining",
+		"Calls to OpenWeather API left in the current window, by window: minute or day.", "window")
+	budgetRejected = metrics.NewCounterVec("weatherapi_upstream_budget_rejected_total",
+		"Calls to OpenWeather API rejected because the call budget was exhausted, by window: minute or day.", "window")
+)
+
+//Budget limits the calls made to OpenWeather API per minute and per day, like the limits of an OpenWeather plan.
+//Windows are fixed: minutes start at second 0 and days at 00:00 UTC. A nil Budget allows every call
+type Budget struct {
+	perMinute int
+	perDay    int
+	maxWait   time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	minute   budgetWindow
+	day      budgetWindow
+	waiting  int
+	rejected uint64
+}
+
+type budgetWindow struct {
+	start time.Time
+	used  int
+}
+
+//BudgetStatus is the state of the call budget, as reported by the admin API
+type BudgetStatus struct {
+	Minute WindowStatus `json:"minute"`
+	Day    WindowStatus `json:"day"`
+	//Waiting is the number of calls queued until the next minute
+	Waiting int `json:"waiting"`
+	//Rejected is the number of calls rejected since the server started
+	Rejected      uint64 `json:"rejected"`
+	UnderPressure bool   `json:"under_pressure"`
+}
+
+//WindowStatus is the state of the budget of a window. Limit and Remaining are omitted for windows without limit
+type WindowStatus struct {
+	Limit     int       `json:"limit,omitempty"`
+	Used      int       `json:"used"`
+	Remaining *int      `json:"remaining,omitempty"`
+	ResetAt   time.Time `json:"reset_at"`
+}
+
+//NewBudget returns a budget allowing perMinute calls per minute and perDay calls per day. Zero means no limit.
+//Calls exceeding the minute budget wait for the next minute up to maxWait, and are rejected otherwise
+func NewBudget(perMinute, perDay int, maxWait time.Duration) *Budget {
+	return &Budget{perMinute: perMinute, perDay: perDay, maxWait: maxWait, now: time.Now}
+}
+
+//Acquire takes a call from the budget. When the minute budget is exhausted, it waits for the next minute if it comes
+//within maxWait and before ctx is done. Otherwise, or when the day budget is exhausted, it returns ErrBudgetExhausted
+func (b *Budget) Acquire(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+
+	for {
+		b.mu.Lock()
+		now := b.now()
+		b.roll(now)
+
+		if b.perDay > 0 && b.day.used >= b.perDay {
+			b.reject("day")
+			b.mu.Unlock()
+			return ErrBudgetExhausted
+		}
+
+		if b.perMinute == 0 || b.minute.used < b.perMinute {
+			b.minute.used++
+			b.day.used++
+			b.updateMetrics()
+			b.mu.Unlock()
+			return nil
+		}
+
+		wait := b.minute.start.Add(time.Minute).Sub(now)
+		if deadline, ok := ctx.Deadline(); wait > b.maxWait || ok && deadline.Before(now.Add(wait)) {
+			b.reject("minute")
+			b.mu.Unlock()
+			return ErrBudgetExhausted
+		}
+		b.waiting++
+		b.mu.Unlock()
+
+		err := sleep(ctx, wait)
+
+		b.mu.Lock()
+		b.waiting--
+		b.mu.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+//UnderPressure reports whether less than 10% of the minute or day budget is left, so callers should prefer
+//cached responses over new calls
+func (b *Budget) UnderPressure() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll(b.now())
+
+	return b.underPressure()
+}
+
+//Status returns the state of the budget
+func (b *Budget) Status() BudgetStatus {
+	if b == nil {
+		return BudgetStatus{}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll(b.now())
+
+	return BudgetStatus{
+		Minute:        windowStatus(b.minute, b.perMinute, time.Minute),
+		Day:           windowStatus(b.day, b.perDay, 24*time.Hour),
+		Waiting:       b.waiting,
+		Rejected:      b.rejected,
+		UnderPressure: b.underPressure(),
+	}
+}
+
+func (b *Budget) underPressure() bool {
+	low := func(w budgetWindow, limit int) bool {
+		return limit > 0 && float64(limit-w.used) < budgetPressure*float64(limit)
+	}
+	return low(b.minute, b.perMinute) || low(b.day, b.perDay)
+}
+
+//roll starts new windows once the current ones are over
+func (b *Budget) roll(now time.Time) {
+	if start := now.Truncate(time.Minute); !start.Equal(b.minute.start) {
+		b.minute = budgetWindow{start: start}
+	}
+	if start := now.UTC().Truncate(24 * time.Hour); !start.Equal(b.day.start) {
+		b.day = budgetWindow{start: start}
+	}
+	b.updateMetrics()
+}
+
+func (b *Budget) reject(window string) {
+	b.rejected++
+	budgetRejected.Inc(window)
+}
+
+func (b *Budget) updateMetrics() {
+	if b.perMinute > 0 {
+		budgetRemaining.Set(float64(b.perMinute-b.minute.used), "minute")
+	}
+	if b.perDay > 0 {
+		budgetRemaining.Set(float64(b.perDay-b.day.used), "day")
+	}
+}
+
+func windowStatus(w budgetWindow, limit int, length time.Duration) WindowStatus {
+	status := WindowStatus{Limit: limit, Used: w.used, ResetAt: w.start.Add(length)}
+	if limit > 0 {
+		remaining := limit - w.used
+		status.Remaining = &remaining
+	}
+	return status
+}
+
+//sleep waits for d, or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,96 @@
+package openweather
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+//nearMinuteEnd returns a clock where the current minute ends in d
+func nearMinuteEnd(d time.Duration) func() time.Time {
+	now := time.Now()
+	offset := now.Truncate(time.Minute).Add(time.Minute - d).Sub(now)
+	return func() time.Time {
+		return time.Now().Add(offset)
+	}
+}
+
+func TestBudgetAcquire(t *testing.T) {
+	tests := []struct {
+		name      string
+		perMinute int
+		perDay    int
+		maxWait   time.Duration
+		timeout   time.Duration
+		calls     int
+		expected  int
+	}{
+		{"No limit", 0, 0, 0, 0, 100, 100},
+		{"Minute limit", 3, 0, 0, 0, 5, 3},
+		{"Day limit", 0, 3, 0, 0, 5, 3},
+		{"Day limit before minute limit", 5, 2, time.Second, 0, 5, 2},
+		{"Queued until next minute", 2, 0, time.Second, 0, 3, 3},
+		{"Wait exceeds max wait", 2, 0, 50 * time.Millisecond, 0, 3, 2},
+		{"Wait exceeds deadline", 2, 0, time.Second, 50 * time.Millisecond, 3, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := NewBudget(test.perMinute, test.perDay, test.maxWait)
+			b.now = nearMinuteEnd(200 * time.Millisecond)
+
+			ctx := context.Background()
+			if test.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.timeout)
+				defer cancel()
+			}
+
+			acquired := 0
+			for i := 0; i < test.calls; i++ {
+				err := b.Acquire(ctx)
+				if err == nil {
+					acquired++
+				} else if err != ErrBudgetExhausted {
+					t.Errorf("Error in test:  %s. Unexpected error: %v", test.name, err)
+				}
+			}
+
+			if acquired != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, acquired, test.expected)
+			}
+			if rejected := b.Status().Rejected; rejected != uint64(test.calls-test.expected) {
+				t.Errorf("Error in test:  %s. Got rejected: %d, Expected: %d", test.name, rejected, test.calls-test.expected)
+			}
+		})
+	}
+}
+
+func TestBudgetStatus(t *testing.T) {
+	b := NewBudget(10, 100, 0)
+	b.now = nearMinuteEnd(30 * time.Second)
+
+	for i := 0; i < 9; i++ {
+		b.Acquire(context.Background())
+		if b.UnderPressure() {
+			t.Fatalf("Unexpected pressure after %d calls", i+1)
+		}
+	}
+	b.Acquire(context.Background())
+
+	status := b.Status()
+	if !status.UnderPressure || !b.UnderPressure() {
+		t.Errorf("Expected budget under pressure")
+	}
+	if status.Minute.Used != 10 || *status.Minute.Remaining != 0 || status.Day.Used != 10 || *status.Day.Remaining != 90 {
+		t.Errorf("Unexpected status. Got: %+v", status)
+	}
+	if reset := status.Minute.ResetAt.Sub(b.now()); reset <= 0 || reset > 30*time.Second {
+		t.Errorf("Unexpected minute reset. Got: %v", reset)
+	}
+
+	var nilBudget *Budget
+	if nilBudget.Acquire(context.Background()) != nil || nilBudget.UnderPressure() {
+		t.Errorf("A nil budget must allow every call")
+	}
+}
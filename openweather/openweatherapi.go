@@ -1,60 +1,205 @@
 package openweather
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/garciacer87/weatherAPI/logging"
+	"github.com/garciacer87/weatherAPI/metrics"
+	"github.com/garciacer87/weatherAPI/requestid"
+	"github.com/garciacer87/weatherAPI/tracing"
 	"github.com/go-resty/resty/v2"
 )
 
+var (
+	upstreamRequests = metrics.NewCounterVec("weatherapi_upstream_requests_total",
+		"Calls made to OpenWeather API, by endpoint and status code.", "endpoint", "code")
+	upstreamErrors = metrics.NewCounterVec("weatherapi_upstream_errors_total",
+		"Calls to OpenWeather API that failed, timed out or answered 5xx, by endpoint.", "endpoint")
+	upstreamDuration = metrics.NewHistogramVec("weatherapi_upstream_request_duration_seconds",
+		"Latency of the calls to OpenWeather API, retries included, by endpoint.", metrics.DefaultBuckets, "endpoint")
+)
+
 //Client used to make requests to openweathermap.org API
 type Client interface {
-	GetWeather(city, country string) (int, []byte)
-	GetForecast(city, country string) (int, []byte)
+	GetWeather(ctx context.Context, city, state, country, unit string) (int, []byte)
+	GetForecast(ctx context.Context, city, state, country, unit string, cnt int) (int, []byte)
+	GetWeatherByCoord(ctx context.Context, lat, lon float64, unit string) (int, []byte)
+	GetForecastByCoord(ctx context.Context, lat, lon float64, unit string, cnt int) (int, []byte)
 }
 
 //clientConfig struct used to store config attributes necessary to connect to openweathermap.org API
 type clientConfig struct {
 	*resty.Client
+	timeout time.Duration
+	logger  *logging.Logger
 }
 
-//NewClient retrieves a new OpenWheater client
-func NewClient(host, apiKey, unit string) Client {
-	c := &clientConfig{resty.New()}
+//NewClient retrieves a new OpenWheater client. unit is the default unit measurement, used when a request does not set one.
+//timeout bounds every call to the API, retries included. Every attempt, retries included, is taken from budget.
+//A nil budget does not limit calls. Failed calls are logged to logger
+func NewClient(host, apiKey, unit string, timeout time.Duration, budget *Budget, logger *logging.Logger) Client {
+	logger = logger.With("component", "openweather")
+	c := &clientConfig{resty.New(), timeout, logger}
 
 	c.SetHostURL(host).
+		SetLogger(restyLogger{logger}).
 		SetRetryCount(3).
 		SetQueryParam("appid", apiKey).
-		SetQueryParam("units", unit)
+		SetQueryParam("units", unit).
+		OnBeforeRequest(func(_ *resty.Client, r *resty.Request) error {
+			//errors returned here stop the retries
+			return budget.Acquire(r.Context())
+		})
 
 	return c
 }
 
-//GetWeather makes a GET request to openweather client to get weather info for a specific city
-func (c *clientConfig) GetWeather(city, country string) (int, []byte) {
-	resp, err := c.R().
-		SetQueryParams(map[string]string{
-			"q": fmt.Sprintf("%s,%s", city, country),
-		}).Get("/data/2.5/weather")
+//GetWeather makes a GET request to openweather client to get weather info for a specific city in the given unit.
+//state is a US state code, like "IL", and empty for cities out of the US
+func (c *clientConfig) GetWeather(ctx context.Context, city, state, country, unit string) (int, []byte) {
+	return c.get(ctx, unit, "/data/2.5/weather", map[string]string{
+		"q": cityQuery(city, state, country),
+	})
+}
+
+//GetForecast makes a GET request to openweather client to get cnt forecast steps (3 hours each) for a specific city.
+//state is a US state code, like "IL", and empty for cities out of the US
+func (c *clientConfig) GetForecast(ctx context.Context, city, state, country, unit string, cnt int) (int, []byte) {
+	return c.get(ctx, unit, "/data/2.5/forecast", map[string]string{
+		"q":   cityQuery(city, state, country),
+		"cnt": strconv.Itoa(cnt),
+	})
+}
+
+//GetWeatherByCoord makes a GET request to openweather client to get weather info for specific geographic coordinates
+func (c *clientConfig) GetWeatherByCoord(ctx context.Context, lat, lon float64, unit string) (int, []byte) {
+	return c.get(ctx, unit, "/data/2.5/weather", map[string]string{
+		"lat": fmtCoord(lat),
+		"lon": fmtCoord(lon),
+	})
+}
+
+//GetForecastByCoord makes a GET request to openweather client to get cnt forecast steps (3 hours each) for specific geographic coordinates
+func (c *clientConfig) GetForecastByCoord(ctx context.Context, lat, lon float64, unit string, cnt int) (int, []byte) {
+	return c.get(ctx, unit, "/data/2.5/forecast", map[string]string{
+		"lat": fmtCoord(lat),
+		"lon": fmtCoord(lon),
+		"cnt": strconv.Itoa(cnt),
+	})
+}
+
+//get makes a GET request bound to ctx and the client timeout, overriding the default unit measurement when unit is not empty
+func (c *clientConfig) get(ctx context.Context, unit, path string, params map[string]string) (int, []byte) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	ctx, span := tracing.Start(ctx, "GET "+path, tracing.KindClient, "openweather.endpoint", path)
+	defer span.End()
+
+	r := c.R().SetContext(ctx)
+	if unit != "" {
+		r.SetQueryParam("units", unit)
+	}
+	if id := requestid.FromContext(ctx); id != "" {
+		r.SetHeader(requestid.Header, id)
+	}
+	if span != nil {
+		r.SetHeader("traceparent", span.TraceParent())
+	}
+
+	start := time.Now()
+	resp, err := r.SetQueryParams(params).Get(path)
+	code, body := http.StatusServiceUnavailable, []byte(`{"code":503, "message":"Error making request to OpenWeather API"}`)
+
+	switch {
+	case err == nil:
+		code, body = resp.StatusCode(), resp.Body()
+	case errors.Is(err, ErrBudgetExhausted):
+		c.logger.WithContext(ctx).Warn("OpenWeather API call budget exhausted", "path", path)
+		span.SetStatusCode(http.StatusServiceUnavailable)
+		return http.StatusServiceUnavailable, []byte(`{"code":503, "message":"OpenWeather API call budget exhausted, try again later"}`)
+	case ctx.Err() == context.DeadlineExceeded:
+		code, body = http.StatusGatewayTimeout, []byte(`{"code":504, "message":"Timeout waiting for OpenWeather API"}`)
+	}
+
+	elapsed := time.Since(start)
+	record(path, code, elapsed)
 
+	span.SetStatusCode(code)
 	if err != nil {
-		return http.StatusServiceUnavailable, []byte(`{"code":503, "message":"Error making request to OpenWeather API"`)
+		span.SetStatus(tracing.StatusError, redact(err.Error()))
 	}
 
-	return resp.StatusCode(), resp.Body()
+	if err != nil {
+		//calls cancelled by the caller, like the forecast when the weather call failed, are expected
+		level := logging.Error
+		if ctx.Err() == context.Canceled {
+			level = logging.Debug
+		}
+		c.logger.WithContext(ctx).Log(level, "Error making request to OpenWeather API", "path", path, "code", code, "latency", elapsed, "error", redact(err.Error()))
+	} else if code >= http.StatusInternalServerError {
+		c.logger.WithContext(ctx).Warn("OpenWeather API answered with an error", "path", path, "code", code, "latency", elapsed)
+	}
+
+	return code, body
 }
 
-//GetForecast makes a GET request to openweather client to get forecast info for a specific city
-func (c *clientConfig) GetForecast(city, country string) (int, []byte) {
-	resp, err := c.R().
-		SetQueryParams(map[string]string{
-			"q":   fmt.Sprintf("%s,%s", city, country),
-			"cnt": "3",
-		}).Get("/data/2.5/forecast")
+//record updates the upstream metrics of a call to the endpoint at p, like "/data/2.5/weather"
+func record(p string, code int, elapsed time.Duration) {
+	endpoint := path.Base(p)
 
-	if err != nil {
-		return http.StatusServiceUnavailable, []byte(`{"code":503, "message":"Error making request to OpenWeather API"`)
+	upstreamRequests.Inc(endpoint, strconv.Itoa(code))
+	upstreamDuration.Observe(elapsed.Seconds(), endpoint)
+	if code >= http.StatusInternalServerError {
+		upstreamErrors.Inc(endpoint)
 	}
+}
+
+//apiKeyRexp matches the API key in request URLs, like the ones found in resty errors
+var apiKeyRexp = regexp.MustCompile(`appid=[^&"\s]*`)
+
+//redact hides the API key from s
+func redact(s string) string {
+	return apiKeyRexp.ReplaceAllString(s, "appid=REDACTED")
+}
+
+//restyLogger sends the messages of resty to the structured logger. Resty reports failed attempts as errors,
+//which are logged as warnings since the call may still succeed on retry
+type restyLogger struct {
+	*logging.Logger
+}
+
+func (rl restyLogger) Errorf(format string, v ...interface{}) {
+	rl.Warn(redact(strings.TrimSpace(fmt.Sprintf(format, v...))))
+}
+
+func (rl restyLogger) Warnf(format string, v ...interface{}) {
+	rl.Warn(redact(strings.TrimSpace(fmt.Sprintf(format, v...))))
+}
+
+func (rl restyLogger) Debugf(format string, v ...interface{}) {
+	rl.Debug(redact(strings.TrimSpace(fmt.Sprintf(format, v...))))
+}
+
+//cityQuery builds the q param of a city, like "Paris,fr", or "Springfield,IL,us" when state is set
+func cityQuery(city, state, country string) string {
+	if state != "" {
+		return fmt.Sprintf("%s,%s,%s", city, state, country)
+	}
+	return fmt.Sprintf("%s,%s", city, country)
+}
 
-	return resp.StatusCode(), resp.Body()
+func fmtCoord(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
 }
@@ -1,10 +1,18 @@
 package openweather
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/garciacer87/weatherAPI/logging"
+	"github.com/garciacer87/weatherAPI/metrics"
+	"github.com/garciacer87/weatherAPI/requestid"
 	"github.com/jarcoal/httpmock"
 )
 
@@ -41,8 +49,20 @@ func newResponder(statusCode int) httpmock.Responder {
 	return resp
 }
 
+//newSlowResponder answers after delay, unless the request context is done before
+func newSlowResponder(delay time.Duration) httpmock.Responder {
+	return func(req *http.Request) (*http.Response, error) {
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+			return httpmock.NewStringResponse(200, `{}`), nil
+		}
+	}
+}
+
 func TestGetWeather(t *testing.T) {
-	c := NewClient("http://localhost:8081", "1234", "metric").(*clientConfig)
+	c := NewClient("http://localhost:8081", "1234", "metric", 10*time.Second, nil, nil).(*clientConfig)
 	httpmock.ActivateNonDefault(c.GetClient())
 	defer httpmock.DeactivateAndReset()
 
@@ -51,7 +71,7 @@ func TestGetWeather(t *testing.T) {
 		httpmock.RegisterResponder("GET", "http://localhost:8081/data/2.5/weather", responder)
 
 		t.Run(test.name, func(t *testing.T) {
-			statusCode, _ := c.GetWeather(test.params.city, test.params.country)
+			statusCode, _ := c.GetWeather(context.Background(), test.params.city, "", test.params.country, "")
 			if statusCode != test.expected {
 				t.Errorf("Error in test: %s\n Got: %v, Expected: %v", test.name, statusCode, test.expected)
 			}
@@ -60,7 +80,7 @@ func TestGetWeather(t *testing.T) {
 }
 
 func TestGetForecast(t *testing.T) {
-	c := NewClient("http://localhost:8081", "1234", "metric").(*clientConfig)
+	c := NewClient("http://localhost:8081", "1234", "metric", 10*time.Second, nil, nil).(*clientConfig)
 	httpmock.ActivateNonDefault(c.GetClient())
 	defer httpmock.DeactivateAndReset()
 
@@ -69,7 +89,7 @@ func TestGetForecast(t *testing.T) {
 		httpmock.RegisterResponder("GET", "http://localhost:8081/data/2.5/forecast", responder)
 
 		t.Run(test.name, func(t *testing.T) {
-			statusCode, _ := c.GetForecast(test.params.city, test.params.country)
+			statusCode, _ := c.GetForecast(context.Background(), test.params.city, "", test.params.country, "", 3)
 			if statusCode != test.expected {
 				t.Errorf("Error in test: %s\n Got: %v, Expected: %v", test.name, statusCode, test.expected)
 			}
@@ -79,7 +99,7 @@ func TestGetForecast(t *testing.T) {
 
 func TestNewClient(t *testing.T) {
 	host := "http://localhost:8081"
-	c := NewClient(host, "1234", "metric").(*clientConfig)
+	c := NewClient(host, "1234", "metric", 10*time.Second, nil, nil).(*clientConfig)
 
 	if c.HostURL != host {
 		t.Errorf("Different hosts. Got: %s, Expected: %s", c.HostURL, host)
@@ -89,3 +109,279 @@ func TestNewClient(t *testing.T) {
 		t.Errorf("Different apiKeys. Got: %s, Expected: %s", c.QueryParam.Get("appid"), "1234")
 	}
 }
+
+func TestGetWeatherByCoord(t *testing.T) {
+	c := NewClient("http://localhost:8081", "1234", "metric", 10*time.Second, nil, nil).(*clientConfig)
+	httpmock.ActivateNonDefault(c.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	for _, test := range tests {
+		responder := newResponder(test.expected)
+		httpmock.RegisterResponder("GET", "http://localhost:8081/data/2.5/weather", responder)
+
+		t.Run(test.name, func(t *testing.T) {
+			statusCode, _ := c.GetWeatherByCoord(context.Background(), 4.61, -74.08, "")
+			if statusCode != test.expected {
+				t.Errorf("Error in test: %s\n Got: %v, Expected: %v", test.name, statusCode, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetForecastByCoord(t *testing.T) {
+	c := NewClient("http://localhost:8081", "1234", "metric", 10*time.Second, nil, nil).(*clientConfig)
+	httpmock.ActivateNonDefault(c.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	for _, test := range tests {
+		responder := newResponder(test.expected)
+		httpmock.RegisterResponder("GET", "http://localhost:8081/data/2.5/forecast", responder)
+
+		t.Run(test.name, func(t *testing.T) {
+			statusCode, _ := c.GetForecastByCoord(context.Background(), 4.61, -74.08, "", 3)
+			if statusCode != test.expected {
+				t.Errorf("Error in test: %s\n Got: %v, Expected: %v", test.name, statusCode, test.expected)
+			}
+		})
+	}
+}
+
+func TestFmtCoord(t *testing.T) {
+	v := fmtCoord(-33.45)
+	if v != "-33.45" {
+		t.Errorf("Coordinate is different than expected. Got: %s, Expected: %s", v, "-33.45")
+	}
+}
+
+func TestGetForecastCount(t *testing.T) {
+	c := NewClient("http://localhost:8081", "1234", "metric", 10*time.Second, nil, nil).(*clientConfig)
+	httpmock.ActivateNonDefault(c.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponderWithQuery("GET", "http://localhost:8081/data/2.5/forecast",
+		"appid=1234&units=metric&q=Bogota,co&cnt=8", newResponder(http.StatusOK))
+
+	statusCode, _ := c.GetForecast(context.Background(), "Bogota", "", "co", "", 8)
+	if statusCode != http.StatusOK {
+		t.Errorf("Unexpected status code. Got: %v, Expected: %v", statusCode, http.StatusOK)
+	}
+}
+
+func TestGetWeatherState(t *testing.T) {
+	c := NewClient("http://localhost:8081", "1234", "metric", 10*time.Second, nil, nil).(*clientConfig)
+	httpmock.ActivateNonDefault(c.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponderWithQuery("GET", "http://localhost:8081/data/2.5/weather",
+		"appid=1234&units=metric&q=Springfield,IL,us", newResponder(http.StatusOK))
+	httpmock.RegisterResponderWithQuery("GET", "http://localhost:8081/data/2.5/forecast",
+		"appid=1234&units=metric&q=Springfield,IL,us&cnt=8", newResponder(http.StatusOK))
+
+	if statusCode, _ := c.GetWeather(context.Background(), "Springfield", "IL", "us", ""); statusCode != http.StatusOK {
+		t.Errorf("Unexpected status code. Got: %v, Expected: %v", statusCode, http.StatusOK)
+	}
+	if statusCode, _ := c.GetForecast(context.Background(), "Springfield", "IL", "us", "", 8); statusCode != http.StatusOK {
+		t.Errorf("Unexpected status code. Got: %v, Expected: %v", statusCode, http.StatusOK)
+	}
+}
+
+func TestRequestUnit(t *testing.T) {
+	c := NewClient("http://localhost:8081", "1234", "metric", 10*time.Second, nil, nil).(*clientConfig)
+	httpmock.ActivateNonDefault(c.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponderWithQuery("GET", "http://localhost:8081/data/2.5/weather",
+		"appid=1234&units=imperial&q=Bogota,co", newResponder(http.StatusOK))
+
+	statusCode, _ := c.GetWeather(context.Background(), "Bogota", "", "co", "imperial")
+	if statusCode != http.StatusOK {
+		t.Errorf("Unexpected status code. Got: %v, Expected: %v", statusCode, http.StatusOK)
+	}
+}
+
+func TestGetWeatherTimeout(t *testing.T) {
+	c := NewClient("http://localhost:8081", "1234", "metric", 50*time.Millisecond, nil, nil).(*clientConfig)
+	httpmock.ActivateNonDefault(c.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://localhost:8081/data/2.5/weather", newSlowResponder(time.Second))
+
+	start := time.Now()
+	statusCode, _ := c.GetWeather(context.Background(), "Bogota", "", "co", "")
+	if statusCode != http.StatusGatewayTimeout {
+		t.Errorf("Unexpected status code. Got: %v, Expected: %v", statusCode, http.StatusGatewayTimeout)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Request was not cancelled on timeout. Took: %v", elapsed)
+	}
+}
+
+func TestGetWeatherCancelled(t *testing.T) {
+	c := NewClient("http://localhost:8081", "1234", "metric", 10*time.Second, nil, nil).(*clientConfig)
+	httpmock.ActivateNonDefault(c.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://localhost:8081/data/2.5/weather", newSlowResponder(100*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	statusCode, _ := c.GetWeather(ctx, "Bogota", "", "co", "")
+	if statusCode != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected status code. Got: %v, Expected: %v", statusCode, http.StatusServiceUnavailable)
+	}
+}
+
+func TestGetWeatherLogsErrors(t *testing.T) {
+	var b bytes.Buffer
+	c := NewClient("http://localhost:8081", "1234", "metric", 50*time.Millisecond, nil, logging.New(&b, logging.Warn)).(*clientConfig)
+	httpmock.ActivateNonDefault(c.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	tests := []struct {
+		name      string
+		responder httpmock.Responder
+		expected  string
+	}{
+		{"Successful response", newResponder(http.StatusOK), ""},
+		{"City not found", newResponder(http.StatusNotFound), ""},
+		{"Error response", httpmock.NewStringResponder(500, `{}`), `"level":"warn","msg":"OpenWeather API answered with an error"`},
+		{"Timeout", newSlowResponder(time.Second), `"level":"error","msg":"Error making request to OpenWeather API"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b.Reset()
+			httpmock.RegisterResponder("GET", "http://localhost:8081/data/2.5/weather", test.responder)
+
+			c.GetWeather(context.Background(), "Bogota", "", "co", "")
+
+			if test.expected == "" && strings.Contains(b.String(), `"component":"openweather","path"`) {
+				t.Errorf("Error in test:  %s. Unexpected log: %s", test.name, b.String())
+			}
+			if !strings.Contains(b.String(), test.expected) {
+				t.Errorf("Error in test:  %s. Got: %s, Expected: %s", test.name, b.String(), test.expected)
+			}
+		})
+	}
+}
+
+func TestRequestIDForwarded(t *testing.T) {
+	c := NewClient("http://localhost:8081", "1234", "metric", 10*time.Second, nil, nil).(*clientConfig)
+	httpmock.ActivateNonDefault(c.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	var forwarded string
+	httpmock.RegisterResponder("GET", "http://localhost:8081/data/2.5/weather", func(req *http.Request) (*http.Response, error) {
+		forwarded = req.Header.Get(requestid.Header)
+		return httpmock.NewStringResponse(200, `{}`), nil
+	})
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		expected string
+	}{
+		{"With request ID", requestid.NewContext(context.Background(), "abc"), "abc"},
+		{"Without request ID", context.Background(), ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c.GetWeather(test.ctx, "Bogota", "", "co", "")
+			if forwarded != test.expected {
+				t.Errorf("Error in test:  %s. Got: %s, Expected: %s", test.name, forwarded, test.expected)
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Key in the middle", `Get "http://host/data/2.5/weather?appid=1234&q=Paris": refused`, `Get "http://host/data/2.5/weather?appid=REDACTED&q=Paris": refused`},
+		{"Key at the end", `Get "http://host/data/2.5/weather?q=Paris&appid=1234": refused`, `Get "http://host/data/2.5/weather?q=Paris&appid=REDACTED": refused`},
+		{"No key", "connection refused", "connection refused"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := redact(test.input); got != test.expected {
+				t.Errorf("Error in test:  %s. Got: %s, Expected: %s", test.name, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	//metrics are global, so they are checked by their increase since this scrape
+	var before strings.Builder
+	metrics.Default.Write(&before)
+
+	record("/data/2.5/test", 200, 20*time.Millisecond)
+	record("/data/2.5/test", 404, 20*time.Millisecond)
+	record("/data/2.5/test", 503, 2*time.Second)
+
+	var b strings.Builder
+	metrics.Default.Write(&b)
+
+	tests := []struct {
+		name     string
+		series   string
+		expected float64
+	}{
+		{"Successful call", `weatherapi_upstream_requests_total{endpoint="test",code="200"}`, 1},
+		{"Client error", `weatherapi_upstream_requests_total{endpoint="test",code="404"}`, 1},
+		{"Upstream error", `weatherapi_upstream_requests_total{endpoint="test",code="503"}`, 1},
+		{"Errors", `weatherapi_upstream_errors_total{endpoint="test"}`, 1},
+		{"Latency", `weatherapi_upstream_request_duration_seconds_bucket{endpoint="test",le="0.025"}`, 2},
+		{"Calls", `weatherapi_upstream_request_duration_seconds_count{endpoint="test"}`, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, ok := metricValue(b.String(), test.series)
+			if !ok {
+				t.Fatalf("Error in test:  %s. Missing metric: %s", test.name, test.series)
+			}
+			prev, _ := metricValue(before.String(), test.series)
+			if value-prev != test.expected {
+				t.Errorf("Error in test:  %s. Got: %v, Expected: %v", test.name, value-prev, test.expected)
+			}
+		})
+	}
+}
+
+//metricValue returns the value of series in the scraped metrics, and whether it was found
+func metricValue(scrape, series string) (float64, bool) {
+	for _, line := range strings.Split(scrape, "\n") {
+		if strings.HasPrefix(line, series+" ") {
+			v, err := strconv.ParseFloat(strings.TrimPrefix(line, series+" "), 64)
+			return v, err == nil
+		}
+	}
+	return 0, false
+}
+
+func TestGetWeatherBudget(t *testing.T) {
+	c := NewClient("http://localhost:8081", "1234", "metric", 10*time.Second, NewBudget(2, 0, 0), nil).(*clientConfig)
+	c.SetRetryWaitTime(time.Millisecond)
+	httpmock.ActivateNonDefault(c.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://localhost:8081/data/2.5/weather", httpmock.NewErrorResponder(fmt.Errorf("connection reset")))
+
+	statusCode, body := c.GetWeather(context.Background(), "Bogota", "", "co", "")
+	if statusCode != http.StatusServiceUnavailable || !strings.Contains(string(body), "budget exhausted") {
+		t.Errorf("Unexpected response. Got: %d %s", statusCode, body)
+	}
+
+	//retries are taken from the budget too
+	if calls := httpmock.GetTotalCallCount(); calls != 2 {
+		t.Errorf("Unexpected calls. Got: %d, Expected: %d", calls, 2)
+	}
+}
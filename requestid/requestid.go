@@ -0,0 +1,47 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+//Header is the HTTP header carrying the request ID, both from clients and to OpenWeather API
+const Header = "X-Request-ID"
+
+//MaxLength is the maximum length of a request ID accepted from clients
+const MaxLength = 128
+
+type contextKey struct{}
+
+//New returns a random request ID, like "4f1c2b7a9d3e8f60a1b2c3d4e5f60718"
+func New() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//Valid reports whether id can be used as a request ID: up to MaxLength printable ASCII characters, without spaces
+func Valid(id string) bool {
+	if id == "" || len(id) > MaxLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+//NewContext returns a copy of ctx carrying id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+//FromContext returns the request ID carried by ctx, or an empty string
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
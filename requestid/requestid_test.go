@@ -0,0 +1,52 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	id := New()
+	if len(id) != 32 || !Valid(id) {
+		t.Errorf("Unexpected request ID: %s", id)
+	}
+
+	if other := New(); other == id {
+		t.Errorf("Request IDs must be unique. Got: %s twice", id)
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		expected bool
+	}{
+		{"Generated ID", "4f1c2b7a9d3e8f60a1b2c3d4e5f60718", true},
+		{"UUID", "123e4567-e89b-12d3-a456-426614174000", true},
+		{"Empty", "", false},
+		{"Too long", strings.Repeat("a", MaxLength+1), false},
+		{"Spaces", "my request", false},
+		{"Line feed", "id\nforged", false},
+		{"Non ASCII", "peticíon", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if valid := Valid(test.id); valid != test.expected {
+				t.Errorf("Error in test:  %s. Got: %v, Expected: %v", test.name, valid, test.expected)
+			}
+		})
+	}
+}
+
+func TestContext(t *testing.T) {
+	if id := FromContext(context.Background()); id != "" {
+		t.Errorf("Got: %s. Expected an empty request ID", id)
+	}
+
+	if id := FromContext(NewContext(context.Background(), "abc")); id != "abc" {
+		t.Errorf("Got: %s. Expected: %s", id, "abc")
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/garciacer87/weatherAPI/apicache"
+	"github.com/garciacer87/weatherAPI/openweather"
+	"github.com/gin-gonic/gin"
+)
+
+//GetCacheStats handler used to get the statistics of every cache, by name
+func GetCacheStats(caches map[string]apicache.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stats := make(map[string]apicache.Stats, len(caches))
+		for name, cache := range caches {
+			stats[name] = cache.Stats(c.Request.Context())
+		}
+
+		c.JSON(http.StatusOK, stats)
+	}
+}
+
+//DeleteCacheKey handler used to purge a single response, like "paris_fr_3_metric_text_local", from every cache.
+//It is registered as a catch-all *key param, since keys of IANA timezones hold slashes, like "America/Santiago"
+func DeleteCacheKey(caches map[string]apicache.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimPrefix(c.Param("key"), "/")
+		if key == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": "Missing cache key"})
+			return
+		}
+
+		for _, cache := range caches {
+			cache.Delete(c.Request.Context(), key)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"code": http.StatusOK, "message": "Cache entry deleted"})
+	}
+}
+
+//FlushCache handler used to purge every response from every cache
+func FlushCache(caches map[string]apicache.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, cache := range caches {
+			cache.Flush(c.Request.Context())
+		}
+
+		c.JSON(http.StatusOK, gin.H{"code": http.StatusOK, "message": "Cache flushed"})
+	}
+}
+
+//GetBudget handler used to get the calls to OpenWeather API used and left in the current minute and day
+func GetBudget(budget *openweather.Budget) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, budget.Status())
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/garciacer87/weatherAPI/apicache"
+	"github.com/garciacer87/weatherAPI/openweather"
+	"github.com/gin-gonic/gin"
+)
+
+func makeMethodRequest(s mockServer, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, nil)
+
+	s.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestCacheAdmin(t *testing.T) {
+	ctx := context.Background()
+	caches := map[string]apicache.Cache{"cache": apicache.New(1), "last_known_good": apicache.New(1)}
+	for _, cache := range caches {
+		cache.SetValue(ctx, "paris_fr", []byte(`{}`))
+		cache.SetValue(ctx, "london_gb", []byte(`{}`))
+		cache.SetValue(ctx, "santiago_cl_3_metric_text_America/Santiago", []byte(`{}`))
+		cache.SetValue(ctx, "lima_pe_3_metric_text_America/Lima", []byte(`{}`))
+		cache.GetValue(ctx, "paris_fr")
+	}
+
+	s := mockServer{gin.New()}
+	s.GET("/cache/stats", GetCacheStats(caches))
+	s.DELETE("/cache/*key", DeleteCacheKey(caches))
+	s.DELETE("/cache", FlushCache(caches))
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		expected int
+		items    int
+	}{
+		{"Stats", "GET", "/cache/stats", 200, 4},
+		{"Delete key with IANA timezone", "DELETE", "/cache/santiago_cl_3_metric_text_America/Santiago", 200, 3},
+		{"Delete key with encoded slash", "DELETE", "/cache/lima_pe_3_metric_text_America%2FLima", 200, 2},
+		{"Delete key", "DELETE", "/cache/paris_fr", 200, 1},
+		{"Delete missing key", "DELETE", "/cache/santiago_cl", 200, 1},
+		{"Missing key", "DELETE", "/cache/", 400, 1},
+		{"Flush", "DELETE", "/cache", 200, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := makeMethodRequest(s, test.method, test.path)
+			if resp.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, resp.Code, test.expected)
+			}
+
+			var stats map[string]apicache.Stats
+			json.Unmarshal(makeMethodRequest(s, "GET", "/cache/stats").Body.Bytes(), &stats)
+			for name := range caches {
+				if stats[name].Items != test.items {
+					t.Errorf("Error in test:  %s. Got %s items: %d, Expected: %d", test.name, name, stats[name].Items, test.items)
+				}
+				if stats[name].Hits != 1 {
+					t.Errorf("Error in test:  %s. Got %s hits: %d, Expected: %d", test.name, name, stats[name].Hits, 1)
+				}
+			}
+		})
+	}
+}
+
+func TestGetBudget(t *testing.T) {
+	budget := openweather.NewBudget(10, 100, 0)
+	budget.Acquire(context.Background())
+
+	s := mockServer{gin.New()}
+	s.GET("/budget", GetBudget(budget))
+
+	resp := makeMethodRequest(s, "GET", "/budget")
+	if resp.Code != http.StatusOK {
+		t.Errorf("Unexpected status code. Got: %d, Expected: %d", resp.Code, http.StatusOK)
+	}
+
+	var status openweather.BudgetStatus
+	json.Unmarshal(resp.Body.Bytes(), &status)
+	if status.Minute.Limit != 10 || *status.Minute.Remaining != 9 || status.Day.Limit != 100 || *status.Day.Remaining != 99 {
+		t.Errorf("Unexpected budget. Got: %s", resp.Body.String())
+	}
+}
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/garciacer87/weatherAPI/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	//defaultRatePerMinute is the rate limit of keys not setting one
+	defaultRatePerMinute = 60
+
+	//clientKey is the gin context key holding the name of the API key that authenticated the request
+	clientKey = "client"
+	//apiClientKey is the gin context key holding the API key that authenticated the request, so handlers can
+	//charge it for extra work
+	apiClientKey = "api_client"
+	//rateLimitedKey is the gin context key set when a handler rejected the request for exceeding the rate limit
+	rateLimitedKey = "rate_limited"
+)
+
+var clientRequests = metrics.NewCounterVec("weatherapi_client_requests_total",
+	"Requests made with each API key, by key name and result: allowed or rate_limited.", "client", "result")
+
+//APIKey is a client key, as set in the API keys file
+type APIKey struct {
+	//Name identifies the client in logs, metrics and usage, so the key itself is never shown
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	//RatePerMinute is the number of requests the client can make per minute. Default value: 60
+	RatePerMinute float64 `json:"rate_per_minute"`
+	//Burst is the number of requests the client can make at once. Default value: RatePerMinute
+	Burst int `json:"burst"`
+}
+
+//KeyUsage is the usage of an API key since the server started
+type KeyUsage struct {
+	Name          string     `json:"name"`
+	RatePerMinute float64    `json:"rate_per_minute"`
+	Burst         int        `json:"burst"`
+	Requests      uint64     `json:"requests"`
+	RateLimited   uint64     `json:"rate_limited"`
+	LastUsed      *time.Time `json:"last_used,omitempty"`
+}
+
+//APIKeys authenticates clients and limits their request rate, per key
+type APIKeys struct {
+	//clients are indexed by the SHA-256 of their key, so lookups do not leak how much of a key matched
+	clients map[[sha256.Size]byte]*apiClient
+}
+
+type apiClient struct {
+	APIKey
+	bucket *tokenBucket
+
+	requests    uint64
+	rateLimited uint64
+	lastUsed    int64
+}
+
+//LoadAPIKeys reads the API keys from the JSON file at path, like:
+//	[{"name": "mobile-app", "key": "5f2b...", "rate_per_minute": 120, "burst": 20}]
+func LoadAPIKeys(path string) (*APIKeys, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("malformed API keys file %s: %v", path, err)
+	}
+
+	return NewAPIKeys(keys)
+}
+
+//NewAPIKeys returns the keys, setting the default rate limits. Every key must have a name and a unique value
+func NewAPIKeys(keys []APIKey) (*APIKeys, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no API keys found")
+	}
+
+	ak := &APIKeys{make(map[[sha256.Size]byte]*apiClient, len(keys))}
+	names := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		if key.Name == "" || key.Key == "" {
+			return nil, fmt.Errorf("API key %d must have a name and a key", i+1)
+		}
+		if key.RatePerMinute < 0 || key.Burst < 0 {
+			return nil, fmt.Errorf("API key %s cannot have a negative rate limit", key.Name)
+		}
+		if names[key.Name] {
+			return nil, fmt.Errorf("API key name %s is repeated", key.Name)
+		}
+
+		if key.RatePerMinute == 0 {
+			key.RatePerMinute = defaultRatePerMinute
+		}
+		if key.Burst == 0 {
+			key.Burst = int(math.Max(1, math.Ceil(key.RatePerMinute)))
+		}
+
+		hash := sha256.Sum256([]byte(key.Key))
+		if _, ok := ak.clients[hash]; ok {
+			return nil, fmt.Errorf("API key of %s is repeated", key.Name)
+		}
+		ak.clients[hash] = &apiClient{APIKey: key, bucket: newTokenBucket(key.RatePerMinute, key.Burst)}
+		names[key.Name] = true
+	}
+
+	return ak, nil
+}
+
+//Usage returns the usage of every key, sorted by name
+func (ak *APIKeys) Usage() []KeyUsage {
+	usage := make([]KeyUsage, 0, len(ak.clients))
+	for _, client := range ak.clients {
+		u := KeyUsage{
+			Name:          client.Name,
+			RatePerMinute: client.RatePerMinute,
+			Burst:         client.Burst,
+			Requests:      atomic.LoadUint64(&client.requests),
+			RateLimited:   atomic.LoadUint64(&client.rateLimited),
+		}
+		if ns := atomic.LoadInt64(&client.lastUsed); ns != 0 {
+			lastUsed := time.Unix(0, ns)
+			u.LastUsed = &lastUsed
+		}
+		usage = append(usage, u)
+	}
+
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Name < usage[j].Name })
+	return usage
+}
+
+func (ak *APIKeys) client(key string) *apiClient {
+	return ak.clients[sha256.Sum256([]byte(key))]
+}
+
+//RequireAPIKey returns a handler used as middleware to reject requests not carrying a valid API key, as an
+//X-API-Key header or as "Authorization: Bearer <key>", and requests exceeding the rate limit of their key.
+//Every request takes a token, and handlers doing more work charge more with chargeAPIKey.
+//X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset tell the client its limit, the requests left and
+//the seconds until the limit is fully restored. Rate limited requests get 429 with Retry-After
+func RequireAPIKey(keys *APIKeys) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("X-API-Key")
+		if header := c.GetHeader("Authorization"); key == "" && strings.HasPrefix(header, "Bearer ") {
+			key = strings.TrimPrefix(header, "Bearer ")
+		}
+
+		client := keys.client(key)
+		if key == "" || client == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": http.StatusUnauthorized, "message": "Invalid or missing API key"})
+			return
+		}
+		c.Set(clientKey, client.Name)
+		c.Set(apiClientKey, client)
+
+		atomic.AddUint64(&client.requests, 1)
+		atomic.StoreInt64(&client.lastUsed, time.Now().UnixNano())
+
+		if !charge(c, client, 0, 1) {
+			return
+		}
+
+		//the request is only known to be allowed once handlers charging more were served
+		c.Next()
+		if !c.GetBool(rateLimitedKey) {
+			clientRequests.Inc(client.Name, "allowed")
+		}
+	}
+}
+
+//chargeAPIKey charges n tokens in all to the API key that authenticated the request, like a batch taking a token per
+//location. The token RequireAPIKey took is exchanged for the n tokens at once, so a rejected request gives it back.
+//When they are not available, the request is aborted with 429 and false is returned. Requests not authenticated
+//with an API key are never charged
+func chargeAPIKey(c *gin.Context, n int) bool {
+	value, _ := c.Get(apiClientKey)
+	client, ok := value.(*apiClient)
+	if !ok || n <= 1 {
+		return true
+	}
+	return charge(c, client, 1, n)
+}
+
+//charge exchanges the held tokens already taken from the bucket of client for n tokens, updating the rate limit
+//headers. When they are not available, the held tokens are given back, the request is aborted with 429 and false
+//is returned
+func charge(c *gin.Context, client *apiClient, held, n int) bool {
+	ok, remaining, reset, retryAfter := client.bucket.exchange(time.Now(), held, n)
+
+	c.Header("X-RateLimit-Limit", strconv.Itoa(client.Burst))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(reset)))
+
+	if ok {
+		return true
+	}
+
+	atomic.AddUint64(&client.rateLimited, 1)
+	clientRequests.Inc(client.Name, "rate_limited")
+	c.Set(rateLimitedKey, true)
+
+	//requests needing more tokens than the burst would never be allowed, so they are rejected without Retry-After
+	message := "Rate limit exceeded"
+	if n > client.Burst {
+		message = fmt.Sprintf("Request counts as %d requests, over the burst of the API key: %d", n, client.Burst)
+	} else {
+		c.Header("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+	}
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"code": http.StatusTooManyRequests, "message": message})
+	return false
+}
+
+//GetKeyUsage handler used to get the usage of every API key
+func GetKeyUsage(keys *APIKeys) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, keys.Usage())
+	}
+}
+
+//tokenBucket allows burst requests at once, refilled at rate requests per second
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(ratePerMinute float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: ratePerMinute / 60, burst: float64(burst), tokens: float64(burst)}
+}
+
+//take takes n tokens at now, or none. It returns whether they were available, the tokens left, how long until the
+//bucket is full again, and how long until n tokens are available when they were not
+func (b *tokenBucket) take(now time.Time, n int) (bool, int, time.Duration, time.Duration) {
+	return b.exchange(now, 0, n)
+}
+
+//exchange gives back held tokens taken before and takes n tokens at now, at once, so nobody else can take the
+//held tokens in between. It returns the same as take
+func (b *tokenBucket) exchange(now time.Time, held, n int) (bool, int, time.Duration, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	b.tokens = math.Min(b.burst, b.tokens+float64(held))
+
+	ok := b.tokens >= float64(n)
+	if ok {
+		b.tokens -= float64(n)
+	}
+
+	reset := b.duration(b.burst - b.tokens)
+	var retryAfter time.Duration
+	if !ok {
+		retryAfter = b.duration(float64(n) - b.tokens)
+	}
+
+	return ok, int(b.tokens), reset, retryAfter
+}
+
+//duration returns how long it takes to refill tokens
+func (b *tokenBucket) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / b.rate * float64(time.Second))
+}
+
+//ceilSeconds rounds d up to whole seconds, as used by Retry-After
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireAPIKey(t *testing.T) {
+	keys, err := NewAPIKeys([]APIKey{
+		{Name: "mobile", Key: "mobile-key", RatePerMinute: 60, Burst: 2},
+		{Name: "web", Key: "web-key", RatePerMinute: 1, Burst: 1},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating keys: %v", err)
+	}
+
+	s := mockServer{gin.New()}
+	s.Use(RequireAPIKey(keys))
+	s.GET("/test", mockRoute)
+
+	tests := []struct {
+		name       string
+		header     string
+		value      string
+		expected   int
+		remaining  string
+		retryAfter string
+	}{
+		{"Missing key", "", "", 401, "", ""},
+		{"Invalid key", "X-API-Key", "other-key", 401, "", ""},
+		{"Bearer without key", "Authorization", "Bearer ", 401, "", ""},
+		{"X-API-Key", "X-API-Key", "mobile-key", 200, "1", ""},
+		{"Bearer", "Authorization", "Bearer mobile-key", 200, "0", ""},
+		{"Burst exceeded", "X-API-Key", "mobile-key", 429, "0", "1"},
+		{"Other key has its own limit", "X-API-Key", "web-key", 200, "0", ""},
+		{"Slow rate", "X-API-Key", "web-key", 429, "0", "60"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/test", nil)
+			if test.header != "" {
+				req.Header.Set(test.header, test.value)
+			}
+			s.ServeHTTP(w, req)
+
+			if w.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, w.Code, test.expected)
+			}
+			if remaining := w.Header().Get("X-RateLimit-Remaining"); remaining != test.remaining {
+				t.Errorf("Error in test:  %s. Got remaining: %q, Expected: %q", test.name, remaining, test.remaining)
+			}
+			if retryAfter := w.Header().Get("Retry-After"); retryAfter != test.retryAfter {
+				t.Errorf("Error in test:  %s. Got Retry-After: %q, Expected: %q", test.name, retryAfter, test.retryAfter)
+			}
+		})
+	}
+
+	usage := keys.Usage()
+	if len(usage) != 2 || usage[0].Name != "mobile" || usage[0].Requests != 3 || usage[0].RateLimited != 1 || usage[0].LastUsed == nil {
+		t.Errorf("Unexpected usage of key mobile. Got: %+v", usage)
+	}
+	if usage[1].Name != "web" || usage[1].Requests != 2 || usage[1].RateLimited != 1 {
+		t.Errorf("Unexpected usage of key web. Got: %+v", usage[1])
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(60, 2)
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		elapsed    time.Duration
+		expected   bool
+		remaining  int
+		retryAfter time.Duration
+	}{
+		{"First token", 0, true, 1, 0},
+		{"Second token", 0, true, 0, 0},
+		{"Empty", 0, false, 0, time.Second},
+		{"Partially refilled", 500 * time.Millisecond, false, 0, 500 * time.Millisecond},
+		{"Refilled", 500 * time.Millisecond, true, 0, 0},
+		{"Refill capped by burst", time.Minute, true, 1, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now = now.Add(test.elapsed)
+			ok, remaining, _, retryAfter := b.take(now, 1)
+			if ok != test.expected || remaining != test.remaining || retryAfter != test.retryAfter {
+				t.Errorf("Error in test:  %s. Got: %v %d %v, Expected: %v %d %v", test.name,
+					ok, remaining, retryAfter, test.expected, test.remaining, test.retryAfter)
+			}
+		})
+	}
+}
+
+func TestTokenBucketMany(t *testing.T) {
+	b := newTokenBucket(60, 5)
+	now := time.Now()
+
+	if ok, remaining, _, _ := b.take(now, 3); !ok || remaining != 2 {
+		t.Errorf("Error taking 3 tokens. Got: %v %d, Expected: %v %d", ok, remaining, true, 2)
+	}
+
+	//tokens are taken all at once or not at all
+	ok, remaining, _, retryAfter := b.take(now, 3)
+	if ok || remaining != 2 || retryAfter != time.Second {
+		t.Errorf("Error taking 3 more tokens. Got: %v %d %v, Expected: %v %d %v", ok, remaining, retryAfter, false, 2, time.Second)
+	}
+
+	//a failed exchange gives the held token back
+	b.take(now, 1)
+	ok, remaining, _, retryAfter = b.exchange(now, 1, 3)
+	if ok || remaining != 2 || retryAfter != time.Second {
+		t.Errorf("Error exchanging 1 token for 3. Got: %v %d %v, Expected: %v %d %v", ok, remaining, retryAfter, false, 2, time.Second)
+	}
+
+	ok, remaining, _, _ = b.exchange(now, 1, 2)
+	if !ok || remaining != 1 {
+		t.Errorf("Error exchanging 1 token for 2. Got: %v %d, Expected: %v %d", ok, remaining, true, 1)
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apikeys")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		err     bool
+		burst   int
+	}{
+		{"Defaults", `[{"name": "mobile", "key": "abc"}]`, false, 60},
+		{"Rate limit", `[{"name": "mobile", "key": "abc", "rate_per_minute": 10, "burst": 3}]`, false, 3},
+		{"Malformed", `{"name": "mobile"}`, true, 0},
+		{"Empty", `[]`, true, 0},
+		{"Missing key", `[{"name": "mobile"}]`, true, 0},
+		{"Repeated key", `[{"name": "mobile", "key": "abc"}, {"name": "web", "key": "abc"}]`, true, 0},
+		{"Repeated name", `[{"name": "mobile", "key": "abc"}, {"name": "mobile", "key": "def"}]`, true, 0},
+		{"Negative rate", `[{"name": "mobile", "key": "abc", "rate_per_minute": -1}]`, true, 0},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, string(rune('a'+i))+".json")
+			ioutil.WriteFile(path, []byte(test.content), 0600)
+
+			keys, err := LoadAPIKeys(path)
+			if (err != nil) != test.err {
+				t.Fatalf("Error in test:  %s. Got error: %v, Expected error: %v", test.name, err, test.err)
+			}
+			if err == nil && keys.client("abc").Burst != test.burst {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, keys.client("abc").Burst, test.burst)
+			}
+		})
+	}
+
+	if _, err := LoadAPIKeys(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("Expected error loading a missing file")
+	}
+}
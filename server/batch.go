@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/garciacer87/weatherAPI/service"
+	"github.com/gin-gonic/gin"
+)
+
+//MaxBatchSize is the maximum number of locations accepted by a single batch request
+const MaxBatchSize = 50
+
+//batchItem represents a location requested in a batch: either city, country and the optional state, or lat and lon
+type batchItem struct {
+	City    *string  `json:"city,omitempty"`
+	State   *string  `json:"state,omitempty"`
+	Country *string  `json:"country,omitempty"`
+	Lat     *float64 `json:"lat,omitempty"`
+	Lon     *float64 `json:"lon,omitempty"`
+}
+
+//batchResult represents the outcome of a single batch item, with its own status code
+type batchResult struct {
+	Request batchItem   `json:"request"`
+	Code    int         `json:"code"`
+	Body    interface{} `json:"response"`
+}
+
+//get exposes the item fields the same way query params are, so it can be validated with the same rules
+func (i batchItem) get(param string) (string, bool) {
+	switch {
+	case param == "city" && i.City != nil:
+		return *i.City, true
+	case param == "state" && i.State != nil:
+		return *i.State, true
+	case param == "country" && i.Country != nil:
+		return *i.Country, true
+	case param == "lat" && i.Lat != nil:
+		return strconv.FormatFloat(*i.Lat, 'f', -1, 64), true
+	case param == "lon" && i.Lon != nil:
+		return strconv.FormatFloat(*i.Lon, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+//GetWeatherBatch handler used to get weather info of many locations at once. Locations are fetched
+//concurrently by up to workers goroutines, and each one gets its own status code
+func GetWeatherBatch(srv service.Service, workers int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var items []batchItem
+		if err := c.ShouldBindJSON(&items); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": []string{"body must be a JSON array of locations"}})
+			return
+		}
+
+		if len(items) == 0 || len(items) > MaxBatchSize {
+			message := fmt.Sprintf("body must contain between 1 and %d locations", MaxBatchSize)
+			c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": []string{message}})
+			return
+		}
+
+		//every location may call OpenWeather API, so the batch counts as a request per location
+		if !chargeAPIKey(c, len(items)) {
+			return
+		}
+
+		ctx := c.Request.Context()
+		opts := getOptions(c)
+		results := make([]batchResult, len(items))
+
+		jobs := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range jobs {
+					results[i] = getBatchResult(ctx, srv, items[i], opts)
+				}
+			}()
+		}
+
+		for i := range items {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+func getBatchResult(ctx context.Context, srv service.Service, item batchItem, opts service.Options) batchResult {
+	if errors := validatePlace(item.get); len(errors) > 0 {
+		return batchResult{item, http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": errors}}
+	}
+
+	respCode, respBody := getWeather(ctx, srv, item.get, opts)
+
+	var body interface{}
+	json.Unmarshal(respBody, &body)
+
+	return batchResult{item, respCode, body}
+}
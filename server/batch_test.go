@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func makeBatchRequest(s mockServer, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/test", strings.NewReader(body))
+
+	s.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestGetWeatherBatch(t *testing.T) {
+	s := mockServer{gin.New()}
+	s.POST("/test", GetWeatherBatch(&mockService{}, 2))
+
+	body := `[
+		{"city": "Paris", "country": "fr"},
+		{"city": "asdfas", "country": "fr"},
+		{"lat": 48.85, "lon": 2.35},
+		{"city": "P@r1s", "country": "fr"},
+		{"lat": 91}
+	]`
+
+	resp := makeBatchRequest(s, body)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code. Got: %d, Expected: %d", resp.Code, http.StatusOK)
+	}
+
+	var results []batchResult
+	json.Unmarshal(resp.Body.Bytes(), &results)
+
+	expected := []int{200, 404, 200, 400, 400}
+	if len(results) != len(expected) {
+		t.Fatalf("Error in results size. Got: %d, Expected: %d", len(results), len(expected))
+	}
+
+	for i, result := range results {
+		if result.Code != expected[i] {
+			t.Errorf("Error in item %d. Got: %d, Expected: %d", i, result.Code, expected[i])
+		}
+	}
+}
+
+func TestGetWeatherBatchRateLimit(t *testing.T) {
+	keys, err := NewAPIKeys([]APIKey{{Name: "batch", Key: "batch-key", RatePerMinute: 1, Burst: 6}})
+	if err != nil {
+		t.Fatalf("Unexpected error creating keys: %v", err)
+	}
+
+	s := mockServer{gin.New()}
+	s.GET("/metrics", GetMetrics(nil))
+	s.Use(RequireAPIKey(keys))
+	s.POST("/test", GetWeatherBatch(&mockService{}, 2))
+
+	batch := func(n int) string {
+		return "[" + strings.Repeat(`{"city": "Paris", "country": "fr"},`, n-1) + `{"city": "Paris", "country": "fr"}]`
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		expected   int
+		remaining  string
+		retryAfter string
+	}{
+		{"Over the burst", batch(7), 429, "6", ""},
+		{"Token per location", batch(3), 200, "3", ""},
+		{"Invalid body takes a single token", `[]`, 400, "2", ""},
+		{"Not enough tokens", batch(3), 429, "2", "60"},
+		{"Rejected batch gives its token back", batch(2), 200, "0", ""},
+	}
+
+	//counters are global, so they are checked by their increase since this scrape
+	before := makeMethodRequest(s, "GET", "/metrics").Body.String()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/test", strings.NewReader(test.body))
+			req.Header.Set("X-API-Key", "batch-key")
+			s.ServeHTTP(w, req)
+
+			if w.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, w.Code, test.expected)
+			}
+			if remaining := w.Header().Get("X-RateLimit-Remaining"); remaining != test.remaining {
+				t.Errorf("Error in test:  %s. Got remaining: %q, Expected: %q", test.name, remaining, test.remaining)
+			}
+			if retryAfter := w.Header().Get("Retry-After"); retryAfter != test.retryAfter {
+				t.Errorf("Error in test:  %s. Got Retry-After: %q, Expected: %q", test.name, retryAfter, test.retryAfter)
+			}
+		})
+	}
+
+	//rejected batches are only counted as rate limited
+	after := makeMethodRequest(s, "GET", "/metrics").Body.String()
+	for result, expected := range map[string]float64{"allowed": 3, "rate_limited": 2} {
+		series := `weatherapi_client_requests_total{client="batch",result="` + result + `"}`
+		value, _ := metricValue(after, series)
+		prev, _ := metricValue(before, series)
+		if value-prev != expected {
+			t.Errorf("Error in %s requests. Got: %v, Expected: %v", result, value-prev, expected)
+		}
+	}
+}
+
+func TestGetWeatherBatchInvalidBody(t *testing.T) {
+	s := mockServer{gin.New()}
+	s.POST("/test", GetWeatherBatch(&mockService{}, 2))
+
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{"Not an array", `{"city": "Paris", "country": "fr"}`, 400},
+		{"Empty array", `[]`, 400},
+		{"Too many items", "[" + strings.Repeat(`{"city": "Paris", "country": "fr"},`, MaxBatchSize) + `{"city": "Paris", "country": "fr"}]`, 400},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := makeBatchRequest(s, test.body)
+			if resp.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, resp.Code, test.expected)
+			}
+		})
+	}
+}
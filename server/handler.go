@@ -1,29 +1,100 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
-	"net/http"
+	"strconv"
 
+	"github.com/garciacer87/weatherAPI/country"
 	"github.com/garciacer87/weatherAPI/service"
 	"github.com/gin-gonic/gin"
 )
 
-//HealthCheck handler used as a health
-func HealthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "UP"})
-}
-
 //GetWeather handler used to get weather info
 func GetWeather(srv service.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		city := c.Query("city")
-		country := c.Query("country")
+		var info service.CacheInfo
+		ctx := service.WithCacheInfo(c.Request.Context(), &info)
+
+		respCode, respBody := getWeather(ctx, srv, c.GetQuery, getOptions(c))
+
+		writeResponse(c, info, respCode, respBody)
+	}
+}
+
+//GetDailyForecast handler used to get the forecast aggregated per day
+func GetDailyForecast(srv service.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var respCode int
+		var respBody []byte
+
+		var info service.CacheInfo
+		ctx := service.WithCacheInfo(c.Request.Context(), &info)
+
+		days, _ := strconv.Atoi(c.Query("days"))
+		if hasCoordinates(c.GetQuery) {
+			lat, _ := strconv.ParseFloat(c.Query("lat"), 64)
+			lon, _ := strconv.ParseFloat(c.Query("lon"), 64)
+			respCode, respBody = srv.GetDailyForecastByCoord(ctx, lat, lon, days)
+		} else {
+			state := stateCode(c.GetQuery)
+			respCode, respBody = srv.GetDailyForecast(ctx, normalizeCity(c.Query("city")), state, country.Code(c.Query("country")), days)
+		}
+
+		writeResponse(c, info, respCode, respBody)
+	}
+}
+
+//writeResponse writes the service response as JSON. X-Cache-Status tells whether it was a miss, or a fresh
+//or stale cached response, and Age how old the cached response is in seconds. When OpenWeather API failed and
+//the last known good response is served, Warning and X-Data-Age tell the client how old its data is
+func writeResponse(c *gin.Context, info service.CacheInfo, respCode int, respBody []byte) {
+	if info.Status != "" {
+		c.Header("X-Cache-Status", info.Status)
+	}
+	if info.Status == service.CacheFresh || info.Status == service.CacheStale {
+		c.Header("Age", strconv.Itoa(int(info.Age.Seconds())))
+	}
+	if info.Status == service.CacheLastKnownGood {
+		c.Header("Warning", `111 - "Revalidation Failed"`)
+		c.Header("X-Data-Age", strconv.Itoa(int(info.Age.Seconds())))
+	}
+
+	var body interface{}
+	json.Unmarshal(respBody, &body)
 
-		respCode, respBody := srv.GetWeather(city, country)
+	c.JSON(respCode, body)
+}
+
+//getWeather calls the service by coordinates or by city, depending on the already validated params
+func getWeather(ctx context.Context, srv service.Service, get lookup, opts service.Options) (int, []byte) {
+	if hasCoordinates(get) {
+		lat, _ := get("lat")
+		lon, _ := get("lon")
+		latValue, _ := strconv.ParseFloat(lat, 64)
+		lonValue, _ := strconv.ParseFloat(lon, 64)
+		return srv.GetWeatherByCoord(ctx, latValue, lonValue, opts)
+	}
 
-		var body interface{}
-		json.Unmarshal(respBody, &body)
+	city, _ := get("city")
+	countryName, _ := get("country")
+	return srv.GetWeather(ctx, normalizeCity(city), stateCode(get), country.Code(countryName), opts)
+}
+
+//stateCode returns the uppercase code of the already validated state param, like "IL", or empty when missing
+func stateCode(get lookup) string {
+	value, _ := get("state")
+	state, _ := country.USState(value)
+	return state
+}
 
-		c.JSON(respCode, body)
+//getOptions builds the service options from already validated query params
+func getOptions(c *gin.Context) service.Options {
+	steps, _ := strconv.Atoi(c.Query("steps"))
+	return service.Options{
+		Steps:  steps,
+		Unit:   c.Query("units"),
+		Format: c.Query("format"),
+		TZ:     c.Query("tz"),
 	}
 }
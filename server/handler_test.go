@@ -1,14 +1,24 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/garciacer87/weatherAPI/service"
 	"github.com/gin-gonic/gin"
 )
 
+//TestMain keeps gin debug messages out of the test output
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
 type params struct {
 	city    string
 	country string
@@ -23,8 +33,12 @@ type mockServer struct {
 }
 
 func (s *mockServer) makeRequest(city, country string) *httptest.ResponseRecorder {
+	return s.makeQueryRequest(fmt.Sprintf("city=%s&country=%s", city, country))
+}
+
+func (s *mockServer) makeQueryRequest(query string) *httptest.ResponseRecorder {
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("GET", fmt.Sprintf("/test?city=%s&country=%s", city, country), nil)
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/test?%s", query), nil)
 
 	s.ServeHTTP(w, req)
 
@@ -33,7 +47,7 @@ func (s *mockServer) makeRequest(city, country string) *httptest.ResponseRecorde
 
 type mockService struct{}
 
-func (ms *mockService) GetWeather(city, country string) (int, []byte) {
+func (ms *mockService) GetWeather(ctx context.Context, city, state, country string, opts service.Options) (int, []byte) {
 	if city == "Paris" {
 		return 200, nil
 	} else if city == "asdfas" {
@@ -43,6 +57,33 @@ func (ms *mockService) GetWeather(city, country string) (int, []byte) {
 	return 500, nil
 }
 
+func (ms *mockService) GetWeatherByCoord(ctx context.Context, lat, lon float64, opts service.Options) (int, []byte) {
+	if lat == 48.85 && lon == 2.35 {
+		return 200, nil
+	}
+
+	return 404, nil
+}
+
+func (ms *mockService) GetDailyForecast(ctx context.Context, city, state, country string, days int) (int, []byte) {
+	if city == "Paris" && country == "fr" && state == "" {
+		return 200, nil
+	}
+	if city == "Springfield" && country == "us" && state == "IL" {
+		return 200, nil
+	}
+
+	return 404, nil
+}
+
+func (ms *mockService) GetDailyForecastByCoord(ctx context.Context, lat, lon float64, days int) (int, []byte) {
+	if lat == 48.85 && lon == 2.35 {
+		return 200, nil
+	}
+
+	return 404, nil
+}
+
 func TestGetWeather(t *testing.T) {
 	mockServer := mockServer{gin.New()}
 	mockService := &mockService{}
@@ -67,3 +108,120 @@ func TestGetWeather(t *testing.T) {
 		})
 	}
 }
+
+func TestGetWeatherByCoord(t *testing.T) {
+	mockServer := mockServer{gin.New()}
+	mockService := &mockService{}
+	mockServer.GET("/test", GetWeather(mockService))
+
+	tests := []struct {
+		name     string
+		query    string
+		expected int
+	}{
+		{"Successful response", "lat=48.85&lon=2.35", 200},
+		{"Not found response", "lat=10&lon=10", 404},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := mockServer.makeQueryRequest(test.query)
+			if resp.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, resp.Code, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetDailyForecast(t *testing.T) {
+	mockServer := mockServer{gin.New()}
+	mockService := &mockService{}
+	mockServer.GET("/test", GetDailyForecast(mockService))
+
+	tests := []struct {
+		name     string
+		query    string
+		expected int
+	}{
+		{"Successful response", "city=Paris&country=fr&days=3", 200},
+		{"Normalized city", "city=%20%20Paris%20&country=fr", 200},
+		{"Normalized country", "city=Paris&country=France", 200},
+		{"Normalized state", "city=Springfield&state=il&country=USA", 200},
+		{"Not found response", "city=asdfas&country=fr", 404},
+		{"Successful response with coordinates", "lat=48.85&lon=2.35", 200},
+		{"Not found response with coordinates", "lat=10&lon=10&days=2", 404},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := mockServer.makeQueryRequest(test.query)
+			if resp.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, resp.Code, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected service.Options
+	}{
+		{"No steps", "city=Paris&country=fr", service.Options{}},
+		{"Steps", "city=Paris&country=fr&steps=8", service.Options{Steps: 8}},
+		{"Units", "city=Paris&country=fr&units=imperial", service.Options{Unit: "imperial"}},
+		{"Format", "city=Paris&country=fr&format=raw", service.Options{Format: service.FormatRaw}},
+		{"Timezone", "city=Paris&country=fr&tz=Europe/Paris", service.Options{TZ: "Europe/Paris"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request, _ = http.NewRequest("GET", fmt.Sprintf("/test?%s", test.query), nil)
+
+			opts := getOptions(c)
+			if opts != test.expected {
+				t.Errorf("Error in test:  %s. Got: %+v, Expected: %+v", test.name, opts, test.expected)
+			}
+		})
+	}
+}
+
+func TestWriteResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		info        service.CacheInfo
+		cacheStatus string
+		age         string
+		dataAge     string
+	}{
+		{"No cache info", service.CacheInfo{}, "", "", ""},
+		{"Cache miss", service.CacheInfo{Status: service.CacheMiss}, "miss", "", ""},
+		{"Fresh response", service.CacheInfo{Status: service.CacheFresh, Age: 30 * time.Second}, "fresh", "30", ""},
+		{"Stale response", service.CacheInfo{Status: service.CacheStale, Age: 3 * time.Minute}, "stale", "180", ""},
+		{"Last known good response", service.CacheInfo{Status: service.CacheLastKnownGood, Age: 20 * time.Minute}, "last-known-good", "", "1200"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+
+			writeResponse(c, test.info, http.StatusOK, []byte(`{"status":"OK"}`))
+
+			if v := w.Header().Get("X-Cache-Status"); v != test.cacheStatus {
+				t.Errorf("Error in X-Cache-Status. Got: %s, Expected: %s", v, test.cacheStatus)
+			}
+			if v := w.Header().Get("Age"); v != test.age {
+				t.Errorf("Error in Age. Got: %s, Expected: %s", v, test.age)
+			}
+			if v := w.Header().Get("X-Data-Age"); v != test.dataAge {
+				t.Errorf("Error in X-Data-Age. Got: %s, Expected: %s", v, test.dataAge)
+			}
+			if v := w.Header().Get("Warning"); (v != "") != (test.dataAge != "") {
+				t.Errorf("Error in Warning. Got: %s", v)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/garciacer87/weatherAPI/apicache"
+	"github.com/garciacer87/weatherAPI/openweather"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	statusUp   = "UP"
+	statusDown = "DOWN"
+
+	//cachePingTimeout bounds the check of every cache server
+	cachePingTimeout = 2 * time.Second
+	//upstreamProbeTimeout bounds every call of the upstream probe, retries included
+	upstreamProbeTimeout = 5 * time.Second
+)
+
+//Dependency is the status of a dependency in the readiness response
+type Dependency struct {
+	Status string `json:"status"`
+	//Code is the status code OpenWeather API answered to the probe
+	Code  int    `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
+	//CheckedAt is when the status was checked, since probe results are cached
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+//UpstreamProbe checks OpenWeather API is reachable and accepts the API key. Results are cached for ttl,
+//so frequent readiness checks do not spend the API quota, and refreshed in the background, so readiness
+//checks never wait for OpenWeather API
+type UpstreamProbe struct {
+	client openweather.Client
+	budget *openweather.Budget
+	ttl    time.Duration
+
+	mu         sync.Mutex
+	status     Dependency
+	refreshing bool
+}
+
+//NewUpstreamProbe returns a probe calling OpenWeather API through client at most once every ttl.
+//While budget is under pressure, the last result is kept so calls are left to weather requests
+func NewUpstreamProbe(client openweather.Client, budget *openweather.Budget, ttl time.Duration) *UpstreamProbe {
+	return &UpstreamProbe{client: client, budget: budget, ttl: ttl}
+}
+
+//Check returns the last probe result right away, starting a probe in the background once it is older than ttl.
+//Until the first probe ends, OpenWeather API is reported as down
+func (p *UpstreamProbe) Check() Dependency {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	checked := !p.status.CheckedAt.IsZero()
+	expired := time.Since(p.status.CheckedAt) >= p.ttl && !p.budget.UnderPressure()
+	if !p.refreshing && (!checked || expired) {
+		p.refreshing = true
+		go p.probe()
+	}
+
+	if !checked {
+		return Dependency{Status: statusDown, Error: "not checked yet"}
+	}
+	return p.status
+}
+
+//probe calls OpenWeather API and keeps the result. It is not bound to any request, so a cancelled check
+//does not cache a failure
+func (p *UpstreamProbe) probe() {
+	ctx, cancel := context.WithTimeout(context.Background(), upstreamProbeTimeout)
+	defer cancel()
+
+	//the weather of a single coordinate is the cheapest call OpenWeather API offers
+	code, body := p.client.GetWeatherByCoord(ctx, 0, 0, "")
+	status := Dependency{Status: statusUp, CheckedAt: time.Now()}
+	if code != http.StatusOK {
+		status.Status, status.Code, status.Error = statusDown, code, upstreamError(code, body)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status, p.refreshing = status, false
+}
+
+//upstreamError returns the message of an OpenWeather API error response, like "Invalid API key", or the status text
+func upstreamError(code int, body []byte) string {
+	var resp struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &resp) == nil && resp.Message != "" {
+		return resp.Message
+	}
+	return http.StatusText(code)
+}
+
+//HealthCheck handler used as a liveness check. It only tells the process is serving requests
+func HealthCheck(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": statusUp})
+}
+
+//ReadinessCheck handler used to check whether the service can serve weather requests. It reports the status of
+//OpenWeather API and of every cache server, answering 503 when any of them is down
+func ReadinessCheck(probe *UpstreamProbe, caches map[string]apicache.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		checks := map[string]Dependency{"openweather": probe.Check()}
+		for name, cache := range caches {
+			checks[name] = checkCache(c.Request.Context(), cache)
+		}
+
+		code, status := http.StatusOK, statusUp
+		for _, check := range checks {
+			if check.Status != statusUp {
+				code, status = http.StatusServiceUnavailable, statusDown
+			}
+		}
+
+		c.JSON(code, gin.H{"status": status, "checks": checks})
+	}
+}
+
+//checkCache pings caches stored in a server. In-memory caches are always up
+func checkCache(ctx context.Context, cache apicache.Cache) Dependency {
+	status := Dependency{Status: statusUp, CheckedAt: time.Now()}
+
+	pinger, ok := cache.(apicache.Pinger)
+	if !ok {
+		return status
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cachePingTimeout)
+	defer cancel()
+	if err := pinger.Ping(ctx); err != nil {
+		status.Status, status.Error = statusDown, err.Error()
+	}
+
+	return status
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/garciacer87/weatherAPI/apicache"
+	"github.com/gin-gonic/gin"
+)
+
+//mockClient answers every call to OpenWeather API with code and body after delay, counting the calls
+type mockClient struct {
+	code  int
+	body  []byte
+	delay time.Duration
+	calls int32
+}
+
+func (mc *mockClient) GetWeather(ctx context.Context, city, state, country, unit string) (int, []byte) {
+	return mc.GetWeatherByCoord(ctx, 0, 0, unit)
+}
+
+func (mc *mockClient) GetForecast(ctx context.Context, city, state, country, unit string, cnt int) (int, []byte) {
+	return mc.GetWeatherByCoord(ctx, 0, 0, unit)
+}
+
+func (mc *mockClient) GetWeatherByCoord(ctx context.Context, lat, lon float64, unit string) (int, []byte) {
+	atomic.AddInt32(&mc.calls, 1)
+	time.Sleep(mc.delay)
+	return mc.code, mc.body
+}
+
+func (mc *mockClient) GetForecastByCoord(ctx context.Context, lat, lon float64, unit string, cnt int) (int, []byte) {
+	return mc.GetWeatherByCoord(ctx, lat, lon, unit)
+}
+
+//closedAddr returns the address of a port nobody listens to
+func closedAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %v", err)
+	}
+	l.Close()
+	return l.Addr().String()
+}
+
+func TestReadinessCheck(t *testing.T) {
+	redisDown := apicache.NewRedis(closedAddr(t), "", "weatherapi:", 1, nil)
+
+	tests := []struct {
+		name     string
+		client   *mockClient
+		caches   map[string]apicache.Cache
+		expected int
+		down     []string
+	}{
+		{"Ready", &mockClient{code: 200}, map[string]apicache.Cache{"cache": apicache.New(1)}, 200, nil},
+		{"Invalid API key", &mockClient{code: 401, body: []byte(`{"cod":401, "message": "Invalid API key"}`)},
+			map[string]apicache.Cache{"cache": apicache.New(1)}, 503, []string{"openweather"}},
+		{"Upstream unreachable", &mockClient{code: 503}, map[string]apicache.Cache{"cache": apicache.New(1)}, 503, []string{"openweather"}},
+		{"Redis unreachable", &mockClient{code: 200}, map[string]apicache.Cache{"cache": redisDown}, 503, []string{"cache"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			probe := NewUpstreamProbe(test.client, nil, time.Minute)
+			probe.probe()
+
+			s := mockServer{gin.New()}
+			s.GET("/health/ready", ReadinessCheck(probe, test.caches))
+
+			resp := makeMethodRequest(s, "GET", "/health/ready")
+			if resp.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, resp.Code, test.expected)
+			}
+
+			var body struct {
+				Status string
+				Checks map[string]Dependency
+			}
+			json.Unmarshal(resp.Body.Bytes(), &body)
+
+			if len(body.Checks) != len(test.caches)+1 {
+				t.Errorf("Error in test:  %s. Got: %d checks, Expected: %d", test.name, len(body.Checks), len(test.caches)+1)
+			}
+			for _, name := range test.down {
+				if check := body.Checks[name]; check.Status != statusDown || check.Error == "" {
+					t.Errorf("Error in test:  %s. Expected %s to be down with an error. Got: %+v", test.name, name, check)
+				}
+			}
+		})
+	}
+}
+
+//waitForProbe waits until the background probe of p ends
+func waitForProbe(t *testing.T, p *UpstreamProbe) {
+	for i := 0; i < 100; i++ {
+		p.mu.Lock()
+		refreshing := p.refreshing
+		p.mu.Unlock()
+		if !refreshing {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Probe did not end")
+}
+
+func TestUpstreamProbeCache(t *testing.T) {
+	client := &mockClient{code: 401, body: []byte(`{"cod":401, "message": "Invalid API key"}`)}
+	probe := NewUpstreamProbe(client, nil, 100*time.Millisecond)
+
+	if check := probe.Check(); check.Status != statusDown || check.Error != "not checked yet" {
+		t.Errorf("Unexpected result before the first probe. Got: %+v", check)
+	}
+	waitForProbe(t, probe)
+
+	for i := 0; i < 3; i++ {
+		if check := probe.Check(); check.Code != 401 || check.Error != "Invalid API key" {
+			t.Errorf("Unexpected probe result. Got: %+v", check)
+		}
+	}
+	if calls := atomic.LoadInt32(&client.calls); calls != 1 {
+		t.Errorf("Error in cached probe. Got: %d calls, Expected: %d", calls, 1)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	probe.Check()
+	waitForProbe(t, probe)
+	if calls := atomic.LoadInt32(&client.calls); calls != 2 {
+		t.Errorf("Error in expired probe. Got: %d calls, Expected: %d", calls, 2)
+	}
+}
+
+func TestUpstreamProbeDoesNotBlock(t *testing.T) {
+	client := &mockClient{code: 200, delay: 300 * time.Millisecond}
+	probe := NewUpstreamProbe(client, nil, time.Millisecond)
+	probe.probe()
+	time.Sleep(5 * time.Millisecond)
+
+	//the result expired, so the check starts a slow probe and answers the last result meanwhile
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if check := probe.Check(); check.Status != statusUp {
+			t.Errorf("Unexpected probe result. Got: %+v", check)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Checks waited for the probe. Got: %v", elapsed)
+	}
+
+	waitForProbe(t, probe)
+	if calls := atomic.LoadInt32(&client.calls); calls != 2 {
+		t.Errorf("Error in concurrent probes. Got: %d calls, Expected: %d", calls, 2)
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+//shutdownHookTimeout bounds the shutdown hooks, once in-flight requests are drained
+const shutdownHookTimeout = 5 * time.Second
+
+//httpConfig holds the http.Server timeouts and the time given to in-flight requests on shutdown
+type httpConfig struct {
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
+	gracePeriod  time.Duration
+}
+
+//shutdownHook flushes or releases a resource on shutdown, like sending the last spans to the collector
+type shutdownHook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+//OnShutdown registers fn to run on shutdown, after in-flight requests are drained. Hooks run in registration order
+func (s *Server) OnShutdown(name string, fn func(ctx context.Context) error) {
+	s.hooks = append(s.hooks, shutdownHook{name, fn})
+}
+
+//ListenAndServe serves requests on addr until SIGINT or SIGTERM is received, then shuts down gracefully
+func (s *Server) ListenAndServe(addr string) error {
+	ctx, stop := signalContext(syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	//the first upstream probe starts right away, so the first readiness check likely finds its result
+	s.probe.Check()
+
+	s.logger.Info("Serving application", "addr", l.Addr().String())
+	return s.Serve(ctx, l)
+}
+
+//Serve serves requests from l until ctx is done. Then it stops accepting connections, waits for in-flight
+//requests up to the grace period and runs the shutdown hooks. Requests still running after the grace period
+//are cut, and the error is returned
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	srv := &http.Server{
+		Handler:           s.Engine,
+		ReadTimeout:       s.http.readTimeout,
+		ReadHeaderTimeout: s.http.readTimeout,
+		WriteTimeout:      s.http.writeTimeout,
+		IdleTimeout:       s.http.idleTimeout,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(l)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.logger.Info("Shutting down, draining in-flight requests", "grace_period", s.http.gracePeriod)
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), s.http.gracePeriod)
+	defer cancel()
+
+	err := srv.Shutdown(drainCtx)
+	if err != nil {
+		s.logger.Error("In-flight requests did not finish within the grace period", "error", err)
+		srv.Close()
+	}
+
+	s.runHooks()
+	s.logger.Info("Server stopped")
+
+	return err
+}
+
+func (s *Server) runHooks() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownHookTimeout)
+	defer cancel()
+
+	for _, hook := range s.hooks {
+		if err := hook.fn(ctx); err != nil {
+			s.logger.Error("Error running shutdown hook", "hook", hook.name, "error", err)
+		}
+	}
+}
+
+//signalContext returns a context cancelled when one of sigs is received
+func signalContext(sigs ...os.Signal) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	go func() {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+		}
+		signal.Stop(ch)
+		cancel()
+	}()
+
+	return ctx, cancel
+}
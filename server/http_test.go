@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestServer(delay, gracePeriod time.Duration) *Server {
+	s := &Server{
+		Engine: gin.New(),
+		http:   httpConfig{time.Second, time.Second, time.Second, gracePeriod},
+	}
+	s.GET("/test", func(c *gin.Context) {
+		time.Sleep(delay)
+		mockRoute(c)
+	})
+
+	return s
+}
+
+func TestServeGracefulShutdown(t *testing.T) {
+	tests := []struct {
+		name        string
+		delay       time.Duration
+		gracePeriod time.Duration
+		expected    int
+		shutdownErr bool
+	}{
+		{"Request drained", 200 * time.Millisecond, time.Second, 200, false},
+		{"Grace period exceeded", 500 * time.Millisecond, 50 * time.Millisecond, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(test.delay, test.gracePeriod)
+
+			var hooks []string
+			s.OnShutdown("first", func(ctx context.Context) error {
+				hooks = append(hooks, "first")
+				return nil
+			})
+			s.OnShutdown("second", func(ctx context.Context) error {
+				hooks = append(hooks, "second")
+				return fmt.Errorf("flush failed")
+			})
+
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Cannot listen: %v", err)
+			}
+			url := fmt.Sprintf("http://%s/test", l.Addr())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			served := make(chan error)
+			go func() {
+				served <- s.Serve(ctx, l)
+			}()
+
+			code := make(chan int)
+			go func() {
+				resp, err := http.Get(url)
+				if err != nil {
+					code <- 0
+					return
+				}
+				resp.Body.Close()
+				code <- resp.StatusCode
+			}()
+
+			//the request is in flight when the shutdown starts
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+
+			if got := <-code; got != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, got, test.expected)
+			}
+			if err := <-served; (err != nil) != test.shutdownErr {
+				t.Errorf("Error in test:  %s. Unexpected shutdown error: %v", test.name, err)
+			}
+			if len(hooks) != 2 || hooks[0] != "first" || hooks[1] != "second" {
+				t.Errorf("Error in test:  %s. Got hooks: %v, Expected: [first second]", test.name, hooks)
+			}
+
+			if _, err := http.Get(url); err == nil {
+				t.Errorf("Error in test:  %s. Expected new connections to be refused", test.name)
+			}
+		})
+	}
+}
+
+func TestIntEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected int
+	}{
+		{"Missing", "", 10},
+		{"Valid", "30", 30},
+		{"Not a number", "abc", 10},
+		{"Zero", "0", 10},
+		{"Negative", "-5", 10},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			os.Setenv("TEST_INT_ENV", test.value)
+			defer os.Unsetenv("TEST_INT_ENV")
+
+			if got := intEnv("TEST_INT_ENV", 10); got != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, got, test.expected)
+			}
+		})
+	}
+}
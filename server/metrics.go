@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/garciacer87/weatherAPI/apicache"
+	"github.com/garciacer87/weatherAPI/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("weatherapi_http_requests_total",
+		"Requests served, by route, method and status code.", "route", "method", "status")
+	httpDuration = metrics.NewHistogramVec("weatherapi_http_request_duration_seconds",
+		"Latency of the requests served, by route, method and status code.", metrics.DefaultBuckets, "route", "method", "status")
+	cacheHitRatio = metrics.NewGaugeVec("weatherapi_cache_hit_ratio",
+		"Share of cache lookups that found a response, by cache.", "cache")
+	cacheItems = metrics.NewGaugeVec("weatherapi_cache_items",
+		"Responses stored, by cache.", "cache")
+)
+
+//RecordMetrics returns a handler used as middleware to count requests and measure their latency.
+//Requests are labeled by route, like "/weather", so query params do not create new series
+func RecordMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+
+		httpRequests.Inc(route, c.Request.Method, status)
+		httpDuration.Observe(time.Since(start).Seconds(), route, c.Request.Method, status)
+	}
+}
+
+//GetMetrics handler used to expose the metrics in the Prometheus text exposition format.
+//Cache metrics are taken from caches on every scrape
+func GetMetrics(caches map[string]apicache.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for name, cache := range caches {
+			stats := cache.Stats(c.Request.Context())
+			cacheHitRatio.Set(stats.HitRatio(), name)
+			cacheItems.Set(float64(stats.Items), name)
+		}
+
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(http.StatusOK)
+		metrics.Default.Write(c.Writer)
+	}
+}
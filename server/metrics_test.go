@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/garciacer87/weatherAPI/apicache"
+	"github.com/gin-gonic/gin"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	cache := apicache.New(1)
+	cache.SetValue(ctx, "paris_fr", []byte(`{}`))
+	cache.GetValue(ctx, "paris_fr")
+	cache.GetValue(ctx, "london_gb")
+
+	s := mockServer{gin.New()}
+	s.Use(RecordMetrics())
+	s.GET("/test", mockRoute)
+	s.GET("/metrics", GetMetrics(map[string]apicache.Cache{"test": cache}))
+
+	//counters are global, so they are checked by their increase since this scrape
+	before := makeMethodRequest(s, "GET", "/metrics").Body.String()
+
+	s.makeRequest("Paris", "fr")
+	s.makeRequest("Paris", "fr")
+	makeMethodRequest(s, "GET", "/missing")
+
+	resp := makeMethodRequest(s, "GET", "/metrics")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code. Got: %d, Expected: %d", resp.Code, http.StatusOK)
+	}
+
+	tests := []struct {
+		name     string
+		series   string
+		counter  bool
+		expected float64
+	}{
+		{"Request count", `weatherapi_http_requests_total{route="/test",method="GET",status="200"}`, true, 2},
+		{"Unmatched route", `weatherapi_http_requests_total{route="unmatched",method="GET",status="404"}`, true, 1},
+		{"Latency", `weatherapi_http_request_duration_seconds_count{route="/test",method="GET",status="200"}`, true, 2},
+		{"Cache hit ratio", `weatherapi_cache_hit_ratio{cache="test"}`, false, 0.5},
+		{"Cache items", `weatherapi_cache_items{cache="test"}`, false, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, ok := metricValue(resp.Body.String(), test.series)
+			if !ok {
+				t.Fatalf("Error in test:  %s. Missing metric: %s", test.name, test.series)
+			}
+			if test.counter {
+				prev, _ := metricValue(before, test.series)
+				value -= prev
+			}
+			if value != test.expected {
+				t.Errorf("Error in test:  %s. Got: %v, Expected: %v", test.name, value, test.expected)
+			}
+		})
+	}
+}
+
+//metricValue returns the value of series in the scraped body, and whether it was found
+func metricValue(body, series string) (float64, bool) {
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, series+" ") {
+			v, err := strconv.ParseFloat(strings.TrimPrefix(line, series+" "), 64)
+			return v, err == nil
+		}
+	}
+	return 0, false
+}
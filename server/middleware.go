@@ -1,42 +1,356 @@
 package server
 
 import (
+	"crypto/subtle"
 	"fmt"
+	"math"
 	"net/http"
-	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/garciacer87/weatherAPI/country"
+	"github.com/garciacer87/weatherAPI/logging"
+	"github.com/garciacer87/weatherAPI/requestid"
+	"github.com/garciacer87/weatherAPI/service"
+	"github.com/garciacer87/weatherAPI/tracing"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/text/unicode/norm"
 )
 
-//ValidateRequest returns a handler used as middleware to validate query params from incoming requests
+//MaxCityLength is the maximum number of characters of a city name, once normalized
+const MaxCityLength = 100
+
+//lookup returns the value of a param and whether it was present, like gin.Context.GetQuery
+type lookup func(param string) (string, bool)
+
+//ValidateRequest returns a handler used as middleware to validate query params from incoming requests.
+//A request must either carry city and country, plus state for US cities when needed, or lat and lon.
+//Optional params (steps, units, format, tz) are checked when present
 func ValidateRequest() gin.HandlerFunc {
-	cityRexp, _ := regexp.Compile(`^[a-zA-Z\s]+$`)
-	countryRexp, _ := regexp.Compile(`^[a-z]{2}$`)
+	return func(c *gin.Context) {
+		errors := validatePlace(c.GetQuery)
+		errors = append(errors, validateOptions(c)...)
+
+		if len(errors) > 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": errors})
+		}
+	}
+}
+
+//ValidatePlace returns a handler used as middleware to validate only the requested place: either city, country and
+//the optional state, or lat and lon
+func ValidatePlace() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		errors := validatePlace(c.GetQuery)
+
+		if len(errors) > 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": errors})
+		}
+	}
+}
 
+//RejectParams returns a handler used as middleware to reject requests carrying any of params, so clients of routes not
+//supporting them learn they are not applied instead of silently getting a different response
+func RejectParams(params ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		errors := make([]string, 0)
+		for _, param := range params {
+			if _, ok := c.GetQuery(param); ok {
+				errors = append(errors, fmt.Sprintf("query param '%s' is not supported by this endpoint", param))
+			}
+		}
+
+		if len(errors) > 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": errors})
+		}
+	}
+}
+
+//ValidateOptions returns a handler used as middleware to validate only the optional query params (steps, units, format, tz)
+func ValidateOptions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		errors := validateOptions(c)
+
+		if len(errors) > 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": errors})
+		}
+	}
+}
 
-		for _, param := range []string{"city", "country"} {
-			value, ok := c.GetQuery(param)
-			if !ok {
-				errors = append(errors, fmt.Sprintf("missing query param: '%s'", param))
-				continue
+//ValidateDays returns a handler used as middleware to validate the optional days query param
+func ValidateDays() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, ok := c.GetQuery("days")
+		if !ok {
+			return
+		}
+
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 1 || days > service.MaxDays {
+			message := []string{fmt.Sprintf("days must be an integer between 1 and %d", service.MaxDays)}
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": message})
+		}
+	}
+}
+
+//RequireAdminToken returns a handler used as middleware to reject requests not carrying
+//the admin token as "Authorization: Bearer <token>"
+func RequireAdminToken(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		given := strings.TrimPrefix(header, "Bearer ")
+
+		if given == header || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": http.StatusUnauthorized, "message": "Invalid or missing admin token"})
+		}
+	}
+}
+
+//AccessLog returns a handler used as middleware to log every request once it is served, along with the
+//requested location, the cache status, the API key name and the request ID. Requests failing with 5xx are logged as errors
+func AccessLog(logger *logging.Logger) gin.HandlerFunc {
+	logger = logger.With("component", "access")
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		level := logging.Info
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			level = logging.Error
+		}
+
+		kv := []interface{}{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+		}
+		for _, param := range []string{"city", "state", "country", "lat", "lon"} {
+			if value, ok := c.GetQuery(param); ok {
+				kv = append(kv, param, value)
 			}
-			if value == "" {
-				errors = append(errors, fmt.Sprintf("%s cannot be empty", param))
+		}
+		if status := c.Writer.Header().Get("X-Cache-Status"); status != "" {
+			kv = append(kv, "cache", status)
+		}
+		if client := c.GetString(clientKey); client != "" {
+			kv = append(kv, "client", client)
+		}
+
+		logger.WithContext(c.Request.Context()).Log(level, "Request served", kv...)
+	}
+}
+
+//Trace returns a handler used as middleware to trace every request in a span named after its route, like
+//"GET /weather", continuing the trace of the W3C traceparent header when present. The span is carried by the request
+//context, so the service and OpenWeather API calls add their spans to it
+func Trace(tracer *tracing.Tracer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := tracing.ContextWithTraceParent(c.Request.Context(), c.GetHeader("traceparent"))
+		ctx, span := tracer.Start(ctx, c.Request.Method+" "+c.FullPath(), tracing.KindServer,
+			"http.method", c.Request.Method,
+			"http.route", c.FullPath(),
+		)
+		defer span.End()
+
+		for _, param := range []string{"city", "state", "country", "lat", "lon"} {
+			if value, ok := c.GetQuery(param); ok {
+				span.SetAttributes(param, value)
 			}
 		}
+		if id := requestid.FromContext(ctx); id != "" {
+			span.SetAttributes("request_id", id)
+		}
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
 
-		if !cityRexp.MatchString(c.Query("city")) {
-			errors = append(errors, "city must be a string")
+		span.SetStatusCode(c.Writer.Status())
+		if status := c.Writer.Header().Get("X-Cache-Status"); status != "" {
+			span.SetAttributes("cache.status", status)
 		}
+	}
+}
 
-		if !countryRexp.MatchString(c.Query("country")) {
-			errors = append(errors, "country must be a two characters string in lowercase")
+//RequestID returns a handler used as middleware to identify every request with the X-Request-ID header sent by the
+//client, or a new one when missing or invalid. The ID is returned in the response, before any other middleware may
+//abort it, and carried by the request context so it reaches the logs and OpenWeather API calls
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
 		}
 
-		if len(errors) > 0 {
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": errors})
+		c.Header(requestid.Header, id)
+		c.Request = c.Request.WithContext(requestid.NewContext(c.Request.Context(), id))
+	}
+}
+
+func hasCoordinates(get lookup) bool {
+	_, lat := get("lat")
+	_, lon := get("lon")
+	return lat || lon
+}
+
+//validatePlace checks either city, country and the optional state, or lat and lon when any of them is present
+func validatePlace(get lookup) []string {
+	if hasCoordinates(get) {
+		errors := validateCoordinates(get)
+		if _, ok := get("state"); ok {
+			errors = append(errors, "state cannot be used along with lat and lon")
+		}
+		return errors
+	}
+	return validateLocation(get)
+}
+
+func validateOptions(c *gin.Context) []string {
+	errors := validateSteps(c)
+	errors = append(errors, validateUnits(c)...)
+	errors = append(errors, validateFormat(c)...)
+	errors = append(errors, validateTZ(c)...)
+	return errors
+}
+
+func validateLocation(get lookup) []string {
+	errors := validateRequired(get, "city", "country")
+
+	city, _ := get("city")
+	city = normalizeCity(city)
+	if n := utf8.RuneCountInString(city); n > MaxCityLength {
+		errors = append(errors, fmt.Sprintf("city cannot be longer than %d characters", MaxCityLength))
+	} else if !validCity(city) {
+		errors = append(errors, "city must only contain letters, spaces, hyphens, apostrophes and periods")
+	}
+
+	if value, _ := get("country"); value != "" {
+		if _, ok := country.Lookup(value); !ok {
+			errors = append(errors, fmt.Sprintf(`unknown country '%s'. country must be an ISO 3166-1 alpha-2 code like "fr", `+
+				`an alpha-3 code like "FRA" or an English name like "France"`, value))
+		}
+	}
+
+	return append(errors, validateState(get)...)
+}
+
+//validateState checks the optional state, which must be a US state code, like "IL", of a city in the US
+func validateState(get lookup) []string {
+	value, ok := get("state")
+	if !ok {
+		return nil
+	}
+
+	if _, valid := country.USState(value); !valid {
+		return []string{fmt.Sprintf(`unknown state '%s'. state must be a US state code like "IL"`, value)}
+	}
+	if countryName, _ := get("country"); countryName != "" && !country.IsUS(countryName) {
+		return []string{"state can only be used with country us"}
+	}
+
+	return nil
+}
+
+//normalizeCity returns city in Unicode NFC form, trimmed and with every run of whitespace collapsed into a single
+//space, so "  Sa\u0303o   Paulo" and "São Paulo" are the same city, and share the same cache entry
+func normalizeCity(city string) string {
+	return strings.Join(strings.Fields(norm.NFC.String(city)), " ")
+}
+
+//validCity reports whether a normalized city has at least a letter, and only letters, combining marks, spaces,
+//hyphens, apostrophes and periods, like "Zürich", "St. John's", "Winston-Salem" or "'s-Hertogenbosch"
+func validCity(city string) bool {
+	hasLetter := false
+	for _, r := range city {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsMark(r), r == ' ', r == '-', r == '\'', r == '’', r == '.':
+		default:
+			return false
+		}
+	}
+	return hasLetter
+}
+
+func validateCoordinates(get lookup) []string {
+	errors := validateRequired(get, "lat", "lon")
+
+	limits := map[string]float64{"lat": 90, "lon": 180}
+	for _, param := range []string{"lat", "lon"} {
+		value, ok := get(param)
+		if !ok || value == "" {
+			continue
+		}
+
+		v, err := strconv.ParseFloat(value, 64)
+		//NaN fails every comparison, so it must be rejected before the range check
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) || v < -limits[param] || v > limits[param] {
+			errors = append(errors, fmt.Sprintf("%s must be a number between %v and %v", param, -limits[param], limits[param]))
+		}
+	}
+
+	return errors
+}
+
+func validateSteps(c *gin.Context) []string {
+	value, ok := c.GetQuery("steps")
+	if !ok {
+		return nil
+	}
+
+	steps, err := strconv.Atoi(value)
+	if err != nil || steps < 1 || steps > service.MaxSteps {
+		return []string{fmt.Sprintf("steps must be an integer between 1 and %d", service.MaxSteps)}
+	}
+
+	return nil
+}
+
+func validateUnits(c *gin.Context) []string {
+	value, ok := c.GetQuery("units")
+	if !ok || service.ValidUnit(value) {
+		return nil
+	}
+
+	return []string{fmt.Sprintf("units must be one of: %s", strings.Join(service.Units(), ", "))}
+}
+
+func validateFormat(c *gin.Context) []string {
+	value, ok := c.GetQuery("format")
+	if !ok || service.ValidFormat(value) {
+		return nil
+	}
+
+	return []string{fmt.Sprintf("format must be one of: %s", strings.Join(service.Formats(), ", "))}
+}
+
+func validateTZ(c *gin.Context) []string {
+	value, ok := c.GetQuery("tz")
+	if !ok || service.ValidTZ(value) {
+		return nil
+	}
+
+	return []string{fmt.Sprintf("tz must be one of: %s, %s or an IANA timezone name", service.TZLocal, service.TZUTC)}
+}
+
+func validateRequired(get lookup, params ...string) []string {
+	errors := make([]string, 0)
+
+	for _, param := range params {
+		value, ok := get(param)
+		if !ok {
+			errors = append(errors, fmt.Sprintf("missing query param: '%s'", param))
+			continue
+		}
+		if value == "" {
+			errors = append(errors, fmt.Sprintf("%s cannot be empty", param))
 		}
 	}
+
+	return errors
 }
@@ -1,8 +1,17 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 
+	"github.com/garciacer87/weatherAPI/logging"
+	"github.com/garciacer87/weatherAPI/requestid"
+	"github.com/garciacer87/weatherAPI/tracing"
 	"github.com/gin-gonic/gin"
 )
 
@@ -20,8 +29,9 @@ func TestValidateHeader(t *testing.T) {
 		{"Missing country", params{"Paris", ""}, 400},
 		{"Wrong chars on city", params{"P@r1s", "fr"}, 400},
 		{"Wrong chars on country", params{"Paris", "f1"}, 400},
-		{"Uppercase country", params{"Paris", "FR"}, 400},
-		{"Country longer than 2 characters", params{"Paris", "fra"}, 400},
+		{"Uppercase country", params{"Paris", "FR"}, 200},
+		{"Alpha-3 country", params{"Paris", "fra"}, 200},
+		{"Unknown country", params{"Paris", "zz"}, 400},
 	}
 
 	for _, test := range tests {
@@ -32,5 +42,551 @@ func TestValidateHeader(t *testing.T) {
 			}
 		})
 	}
+}
+
+func TestValidateCity(t *testing.T) {
+	s := mockServer{gin.New()}
+	s.Use(ValidateRequest()).GET("/test")
+
+	tests := []struct {
+		name     string
+		city     string
+		expected int
+	}{
+		{"Accented letters", "São Paulo", 200},
+		{"Umlaut", "Zürich", 200},
+		{"Acute accent", "Bogotá", 200},
+		{"Period and apostrophe", "St. John's", 200},
+		{"Typographic apostrophe", "St. John’s", 200},
+		{"Hyphen", "Winston-Salem", 200},
+		{"Leading apostrophe", "'s-Hertogenbosch", 200},
+		{"Decomposed accent", "Sa\u0303o Paulo", 200},
+		{"Cedilla", "Curaçao", 200},
+		{"Polish letters", "Łódź", 200},
+		{"Turkish dotted I", "İstanbul", 200},
+		{"Vietnamese", "Hà Nội", 200},
+		{"Cyrillic", "Москва", 200},
+		{"Greek", "Αθήνα", 200},
+		{"Arabic", "القاهرة", 200},
+		{"Hebrew", "תל אביב", 200},
+		{"Japanese", "東京", 200},
+		{"Korean", "서울", 200},
+		{"Devanagari with marks", "नई दिल्ली", 200},
+		{"Thai with marks", "กรุงเทพมหานคร", 200},
+		{"Surrounding and repeated spaces", "  Rio   de Janeiro ", 200},
+		{"Maximum length", strings.Repeat("a", MaxCityLength), 200},
+		{"Too long", strings.Repeat("a", MaxCityLength+1), 400},
+		{"Only spaces", "   ", 400},
+		{"Only punctuation", ".-'", 400},
+		{"Digits", "P4ris", 400},
+		{"Symbols", "P@ris", 400},
+		{"Comma", "Paris,fr", 400},
+		{"Emoji", "Paris 🗼", 400},
+		{"Tab collapsed into a space", "Par\tis", 200},
+		{"Null character", "Par\x00is", 400},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query := url.Values{"city": {test.city}, "country": {"fr"}}
+			resp := s.makeQueryRequest(query.Encode())
+			if resp.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d. %s", test.name, resp.Code, test.expected, resp.Body.String())
+			}
+		})
+	}
+}
+
+func TestValidateCountry(t *testing.T) {
+	s := mockServer{gin.New()}
+	s.Use(ValidateRequest()).GET("/test")
+
+	tests := []struct {
+		name     string
+		country  string
+		expected int
+		message  string
+	}{
+		{"Lowercase alpha-2", "fr", 200, ""},
+		{"Uppercase alpha-2", "FR", 200, ""},
+		{"Alpha-3", "FRA", 200, ""},
+		{"English name", "France", 200, ""},
+		{"Name with spaces", "United Kingdom", 200, ""},
+		{"Name without accents", "Cote d'Ivoire", 200, ""},
+		{"Unknown alpha-2", "zz", 400, "unknown country 'zz'"},
+		{"Unknown alpha-3", "ZZZ", 400, "unknown country 'ZZZ'"},
+		{"Unknown name", "Atlantis", 400, "alpha-2 code like"},
+		{"Empty", "", 400, "country cannot be empty"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query := url.Values{"city": {"Paris"}, "country": {test.country}}
+			resp := s.makeQueryRequest(query.Encode())
+			if resp.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, resp.Code, test.expected)
+			}
+			if !strings.Contains(resp.Body.String(), test.message) {
+				t.Errorf("Error in test:  %s. Got: %s, Expected message: %s", test.name, resp.Body.String(), test.message)
+			}
+		})
+	}
+}
+
+func TestValidateState(t *testing.T) {
+	s := mockServer{gin.New()}
+	s.Use(ValidateRequest()).GET("/test")
+
+	tests := []struct {
+		name     string
+		query    url.Values
+		expected int
+		message  string
+	}{
+		{"Without state", url.Values{"city": {"Springfield"}, "country": {"us"}}, 200, ""},
+		{"Uppercase state", url.Values{"city": {"Springfield"}, "state": {"IL"}, "country": {"us"}}, 200, ""},
+		{"Lowercase state", url.Values{"city": {"Springfield"}, "state": {"il"}, "country": {"USA"}}, 200, ""},
+		{"District of Columbia", url.Values{"city": {"Washington"}, "state": {"DC"}, "country": {"United States"}}, 200, ""},
+		{"Unknown state", url.Values{"city": {"Springfield"}, "state": {"ZZ"}, "country": {"us"}}, 400, "unknown state 'ZZ'"},
+		{"State name", url.Values{"city": {"Springfield"}, "state": {"Illinois"}, "country": {"us"}}, 400, "US state code"},
+		{"Empty state", url.Values{"city": {"Springfield"}, "state": {""}, "country": {"us"}}, 400, "unknown state ''"},
+		{"Country out of US", url.Values{"city": {"Paris"}, "state": {"TX"}, "country": {"fr"}}, 400, "state can only be used with country us"},
+		{"With coordinates", url.Values{"lat": {"39.8"}, "lon": {"-89.64"}, "state": {"IL"}}, 400, "state cannot be used along with lat and lon"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := s.makeQueryRequest(test.query.Encode())
+			if resp.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, resp.Code, test.expected)
+			}
+			if !strings.Contains(resp.Body.String(), test.message) {
+				t.Errorf("Error in test:  %s. Got: %s, Expected message: %s", test.name, resp.Body.String(), test.message)
+			}
+		})
+	}
+}
+
+func TestNormalizeCity(t *testing.T) {
+	tests := []struct {
+		name     string
+		city     string
+		expected string
+	}{
+		{"Unchanged", "Paris", "Paris"},
+		{"Trimmed", "  Paris\t", "Paris"},
+		{"Collapsed whitespace", "Rio \t de\n\nJaneiro", "Rio de Janeiro"},
+		{"Composed accent", "Sa\u0303o Paulo", "S\u00e3o Paulo"},
+		{"Already composed", "S\u00e3o Paulo", "S\u00e3o Paulo"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if city := normalizeCity(test.city); city != test.expected {
+				t.Errorf("Error in test:  %s. Got: %q, Expected: %q", test.name, city, test.expected)
+			}
+		})
+	}
+}
 
+func TestValidateCoordinates(t *testing.T) {
+	s := mockServer{gin.New()}
+	s.Use(ValidateRequest()).GET("/test")
+
+	tests := []struct {
+		name     string
+		query    string
+		expected int
+	}{
+		{"Successful response", "lat=-33.4569&lon=-70.6483", 200},
+		{"Missing lon", "lat=-33.4569", 400},
+		{"Empty lat", "lat=&lon=-70.6483", 400},
+		{"Non numeric lat", "lat=abc&lon=-70.6483", 400},
+		{"Lat out of range", "lat=91&lon=-70.6483", 400},
+		{"Lon out of range", "lat=-33.4569&lon=-180.5", 400},
+		{"NaN lat", "lat=NaN&lon=0", 400},
+		{"Lowercase NaN lon", "lat=0&lon=nan", 400},
+		{"Infinite lat", "lat=Inf&lon=0", 400},
+		{"Negative infinite lon", "lat=0&lon=-Inf", 400},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := s.makeQueryRequest(test.query)
+			if resp.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, resp.Code, test.expected)
+			}
+		})
+	}
+}
+
+func TestValidateSteps(t *testing.T) {
+	s := mockServer{gin.New()}
+	s.Use(ValidateRequest()).GET("/test")
+
+	tests := []struct {
+		name     string
+		query    string
+		expected int
+	}{
+		{"Successful response", "city=Paris&country=fr&steps=8", 200},
+		{"Successful response with coordinates", "lat=48.85&lon=2.35&steps=40", 200},
+		{"Zero steps", "city=Paris&country=fr&steps=0", 400},
+		{"Too many steps", "city=Paris&country=fr&steps=41", 400},
+		{"Non numeric steps", "city=Paris&country=fr&steps=abc", 400},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := s.makeQueryRequest(test.query)
+			if resp.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, resp.Code, test.expected)
+			}
+		})
+	}
+}
+
+func TestValidateDays(t *testing.T) {
+	s := mockServer{gin.New()}
+	s.Use(ValidateDays()).GET("/test")
+
+	tests := []struct {
+		name     string
+		query    string
+		expected int
+	}{
+		{"Successful response", "city=Paris&country=fr&days=5", 200},
+		{"Default days", "city=Paris&country=fr", 200},
+		{"Zero days", "city=Paris&country=fr&days=0", 400},
+		{"Too many days", "city=Paris&country=fr&days=6", 400},
+		{"Non numeric days", "city=Paris&country=fr&days=abc", 400},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := s.makeQueryRequest(test.query)
+			if resp.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, resp.Code, test.expected)
+			}
+		})
+	}
+}
+
+func TestValidatePlace(t *testing.T) {
+	s := mockServer{gin.New()}
+	s.Use(ValidatePlace()).GET("/test")
+
+	tests := []struct {
+		name     string
+		query    string
+		expected int
+	}{
+		{"City", "city=Paris&country=fr", 200},
+		{"Coordinates", "lat=48.85&lon=2.35", 200},
+		{"Missing country", "city=Paris", 400},
+		{"Lat out of range", "lat=91&lon=2.35", 400},
+		{"Options not checked", "city=Paris&country=fr&units=kelvin", 200},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := s.makeQueryRequest(test.query)
+			if resp.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, resp.Code, test.expected)
+			}
+		})
+	}
+}
+
+func TestRejectParams(t *testing.T) {
+	s := mockServer{gin.New()}
+	s.Use(RejectParams("units", "tz")).GET("/test")
+
+	tests := []struct {
+		name     string
+		query    string
+		expected int
+		message  string
+	}{
+		{"Without params", "city=Paris&country=fr", 200, ""},
+		{"Units", "city=Paris&country=fr&units=imperial", 400, "query param 'units' is not supported by this endpoint"},
+		{"Empty tz", "city=Paris&country=fr&tz=", 400, "query param 'tz' is not supported by this endpoint"},
+		{"Other params", "city=Paris&country=fr&days=3", 200, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := s.makeQueryRequest(test.query)
+			if resp.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, resp.Code, test.expected)
+			}
+			if !strings.Contains(resp.Body.String(), test.message) {
+				t.Errorf("Error in test:  %s. Got: %s, Expected message: %s", test.name, resp.Body.String(), test.message)
+			}
+		})
+	}
+}
+
+func TestValidateUnits(t *testing.T) {
+	s := mockServer{gin.New()}
+	s.Use(ValidateRequest()).GET("/test")
+
+	tests := []struct {
+		name     string
+		query    string
+		expected int
+	}{
+		{"Metric units", "city=Paris&country=fr&units=metric", 200},
+		{"Imperial units", "city=Paris&country=fr&units=imperial", 200},
+		{"Standard units", "lat=48.85&lon=2.35&units=standard", 200},
+		{"Unknown units", "city=Paris&country=fr&units=kelvin", 400},
+		{"Empty units", "city=Paris&country=fr&units=", 400},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := s.makeQueryRequest(test.query)
+			if resp.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, resp.Code, test.expected)
+			}
+		})
+	}
+}
+
+func TestValidateFormat(t *testing.T) {
+	s := mockServer{gin.New()}
+	s.Use(ValidateRequest()).GET("/test")
+
+	tests := []struct {
+		name     string
+		query    string
+		expected int
+	}{
+		{"Text format", "city=Paris&country=fr&format=text", 200},
+		{"Raw format", "lat=48.85&lon=2.35&format=raw", 200},
+		{"Unknown format", "city=Paris&country=fr&format=xml", 400},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := s.makeQueryRequest(test.query)
+			if resp.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, resp.Code, test.expected)
+			}
+		})
+	}
+}
+
+func TestValidateTZ(t *testing.T) {
+	s := mockServer{gin.New()}
+	s.Use(ValidateRequest()).GET("/test")
+
+	tests := []struct {
+		name     string
+		query    string
+		expected int
+	}{
+		{"Local timezone", "city=Paris&country=fr&tz=local", 200},
+		{"UTC timezone", "city=Paris&country=fr&tz=utc", 200},
+		{"IANA timezone", "lat=48.85&lon=2.35&tz=America/Santiago", 200},
+		{"Unknown timezone", "city=Paris&country=fr&tz=Mars/Olympus", 400},
+		{"Empty timezone", "city=Paris&country=fr&tz=", 400},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := s.makeQueryRequest(test.query)
+			if resp.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, resp.Code, test.expected)
+			}
+		})
+	}
+}
+
+func TestRequireAdminToken(t *testing.T) {
+	s := mockServer{gin.New()}
+	s.Use(RequireAdminToken("secret")).GET("/test", mockRoute)
+
+	tests := []struct {
+		name          string
+		authorization string
+		expected      int
+	}{
+		{"Valid token", "Bearer secret", 200},
+		{"Missing token", "", 401},
+		{"Wrong token", "Bearer other", 401},
+		{"Missing bearer scheme", "secret", 401},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/test", nil)
+			req.Header.Set("Authorization", test.authorization)
+			s.ServeHTTP(w, req)
+
+			if w.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, w.Code, test.expected)
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var logs bytes.Buffer
+	s := mockServer{gin.New()}
+	s.Use(RequestID(), AccessLog(logging.New(&logs, logging.Info))).GET("/test", func(c *gin.Context) {
+		c.Header("X-Cache-Status", "fresh")
+		mockRoute(c)
+	})
+
+	tests := []struct {
+		name     string
+		query    string
+		expected map[string]interface{}
+	}{
+		{"City request", "city=Paris&country=fr", map[string]interface{}{
+			"level": "info", "method": "GET", "path": "/test", "status": float64(200), "city": "Paris", "country": "fr", "cache": "fresh",
+		}},
+		{"Coordinates request", "lat=48.85&lon=2.35", map[string]interface{}{
+			"lat": "48.85", "lon": "2.35", "city": nil,
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs.Reset()
+			s.makeQueryRequest(test.query)
+
+			var entry map[string]interface{}
+			if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+				t.Fatalf("Error in test:  %s. Invalid log entry: %s", test.name, logs.String())
+			}
+			for key, value := range test.expected {
+				if entry[key] != value {
+					t.Errorf("Error in test:  %s. Got %s: %v, Expected: %v", test.name, key, entry[key], value)
+				}
+			}
+			if _, ok := entry["latency_ms"].(float64); !ok {
+				t.Errorf("Error in test:  %s. Missing latency: %s", test.name, logs.String())
+			}
+			if id, _ := entry["request_id"].(string); !requestid.Valid(id) {
+				t.Errorf("Error in test:  %s. Missing request ID: %s", test.name, logs.String())
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	s := mockServer{gin.New()}
+	s.Use(RequestID(), ValidateRequest()).GET("/test", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"request_id": requestid.FromContext(c.Request.Context())})
+	})
+
+	tests := []struct {
+		name     string
+		query    string
+		header   string
+		expected int
+		echoed   bool
+	}{
+		{"Generated ID", "city=Paris&country=fr", "", 200, false},
+		{"Client ID", "city=Paris&country=fr", "abc-123", 200, true},
+		{"Invalid client ID", "city=Paris&country=fr", "abc 123", 200, false},
+		{"Bad request", "city=P@r1s&country=fr", "abc-123", 400, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/test?"+test.query, nil)
+			if test.header != "" {
+				req.Header.Set(requestid.Header, test.header)
+			}
+			s.ServeHTTP(w, req)
+
+			if w.Code != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, w.Code, test.expected)
+			}
+
+			id := w.Header().Get(requestid.Header)
+			if !requestid.Valid(id) || (id == test.header) != test.echoed {
+				t.Errorf("Error in test:  %s. Unexpected request ID: %s", test.name, id)
+			}
+
+			var body map[string]interface{}
+			json.Unmarshal(w.Body.Bytes(), &body)
+			if test.expected == 200 && body["request_id"] != id {
+				t.Errorf("Error in test:  %s. Got: %v, Expected: %s", test.name, body["request_id"], id)
+			}
+		})
+	}
+}
+
+func TestTrace(t *testing.T) {
+	exporter := tracing.NewMemoryExporter()
+	s := mockServer{gin.New()}
+	s.Use(RequestID(), Trace(tracing.New(exporter))).GET("/test", func(c *gin.Context) {
+		_, span := tracing.Start(c.Request.Context(), "service.GetWeather", tracing.KindInternal)
+		span.End()
+
+		c.Header("X-Cache-Status", "miss")
+		if c.Query("city") == "Paris" {
+			mockRoute(c)
+			return
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{"code": http.StatusServiceUnavailable})
+	})
+
+	tests := []struct {
+		name        string
+		query       string
+		traceparent string
+		code        int
+		status      tracing.StatusCode
+	}{
+		{"Successful response", "city=Paris&country=fr", "", 200, tracing.StatusUnset},
+		{"Upstream error", "city=London&country=gb", "", 503, tracing.StatusError},
+		{"Remote parent", "city=Paris&country=fr", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", 200, tracing.StatusUnset},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exporter.Reset()
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/test?"+test.query, nil)
+			if test.traceparent != "" {
+				req.Header.Set("traceparent", test.traceparent)
+			}
+			s.ServeHTTP(w, req)
+
+			spans := exporter.Spans()
+			if len(spans) != 2 {
+				t.Fatalf("Error in test:  %s. Got spans: %d, Expected: %d", test.name, len(spans), 2)
+			}
+			child, root := spans[0], spans[1]
+
+			if root.Name != "GET /test" || root.Kind != tracing.KindServer || root.Status != test.status {
+				t.Errorf("Error in test:  %s. Unexpected root span: %+v", test.name, root)
+			}
+			if child.ParentID != root.SpanID || child.TraceID != root.TraceID {
+				t.Errorf("Error in test:  %s. Child span is not in the request trace", test.name)
+			}
+			if remote := test.traceparent != ""; remote != (root.TraceID.String() == "4bf92f3577b34da6a3ce929d0e0e4736") {
+				t.Errorf("Error in test:  %s. Got trace: %s", test.name, root.TraceID)
+			}
+
+			expected := map[string]interface{}{
+				"http.status_code": test.code,
+				"cache.status":     "miss",
+				"request_id":       w.Header().Get(requestid.Header),
+			}
+			for key, value := range expected {
+				if v, _ := root.Attribute(key); v != value {
+					t.Errorf("Error in test:  %s. Got %s: %v, Expected: %v", test.name, key, v, value)
+				}
+			}
+		})
+	}
 }
@@ -1,21 +1,40 @@
 package server
 
 import (
+	"context"
+	"io"
 	"os"
 	"strconv"
+	"time"
 
+	"github.com/garciacer87/weatherAPI/apicache"
+	"github.com/garciacer87/weatherAPI/logging"
+	"github.com/garciacer87/weatherAPI/openweather"
 	"github.com/garciacer87/weatherAPI/service"
+	"github.com/garciacer87/weatherAPI/tracing"
 	"github.com/gin-gonic/gin"
 )
 
 //Server impl
 type Server struct {
 	*gin.Engine
-	service service.Service
+	service      service.Service
+	batchWorkers int
+	//caches are the caches used by the service, by name, managed through the admin API
+	caches map[string]apicache.Cache
+	probe  *UpstreamProbe
+	budget *openweather.Budget
+	//apiKeys authenticate the weather endpoints. Nil leaves them open
+	apiKeys    *APIKeys
+	adminToken string
+	logger     *logging.Logger
+	tracer     *tracing.Tracer
+	http       httpConfig
+	hooks      []shutdownHook
 }
 
-//New returns new gin server
-func New() Server {
+//New returns new gin server. Requests and errors are logged to logger. It fails when the API keys file cannot be loaded
+func New(logger *logging.Logger) (*Server, error) {
 	host := os.Getenv("OPENWEATHERMAP_HOST")
 	apiKey := os.Getenv("OPENWEATHERMAP_APIKEY")
 
@@ -30,17 +49,192 @@ func New() Server {
 		cacheDuration, _ = strconv.Atoi(d)
 	}
 
-	service := service.New(host, apiKey, unit, cacheDuration)
-	s := Server{gin.New(), service}
+	staleDuration := 5
+	sd := os.Getenv("CACHE_STALE_DURATION")
+	if sd != "" {
+		staleDuration, _ = strconv.Atoi(sd)
+	}
+
+	maxStaleness := 60
+	ms := os.Getenv("CACHE_MAX_STALENESS")
+	if ms != "" {
+		maxStaleness, _ = strconv.Atoi(ms)
+	}
+
+	upstreamTimeout := 10
+	t := os.Getenv("UPSTREAM_TIMEOUT")
+	if t != "" {
+		upstreamTimeout, _ = strconv.Atoi(t)
+	}
+
+	budgetMaxWait := 5
+	bw := os.Getenv("UPSTREAM_BUDGET_MAX_WAIT")
+	if bw != "" {
+		budgetMaxWait, _ = strconv.Atoi(bw)
+	}
+
+	batchWorkers := 5
+	w := os.Getenv("BATCH_WORKERS")
+	if n, _ := strconv.Atoi(w); n > 0 {
+		batchWorkers = n
+	}
+
+	cache := newCache(cachePrefix, cacheDuration+staleDuration, logger)
+
+	caches := map[string]apicache.Cache{"cache": cache}
+
+	var lastKnownGood apicache.Cache
+	if maxStaleness > 0 {
+		lastKnownGood = newCache(lastKnownGoodPrefix, maxStaleness, logger)
+		caches["last_known_good"] = lastKnownGood
+	}
+
+	//calls to OpenWeather API are only limited when a budget is set
+	var budget *openweather.Budget
+	perMinute, perDay := intEnv("UPSTREAM_CALLS_PER_MINUTE", 0), intEnv("UPSTREAM_CALLS_PER_DAY", 0)
+	if perMinute > 0 || perDay > 0 {
+		budget = openweather.NewBudget(perMinute, perDay, time.Duration(budgetMaxWait)*time.Second)
+	}
+
+	//the service and the readiness probe share the client, so both are seen in the upstream metrics and budget
+	client := openweather.NewClient(host, apiKey, unit, time.Duration(upstreamTimeout)*time.Second, budget, logger)
+
+	service := service.New(service.Config{
+		Client:  client,
+		Unit:    unit,
+		SoftTTL: time.Duration(cacheDuration) * time.Minute,
+		Budget:  budget,
+
+		LastKnownGood: lastKnownGood,
+		Logger:        logger,
+	}, cache)
+	s := &Server{
+		Engine:       gin.New(),
+		service:      service,
+		batchWorkers: batchWorkers,
+		caches:       caches,
+		probe:        NewUpstreamProbe(client, budget, time.Duration(intEnv("READINESS_PROBE_INTERVAL", 30))*time.Second),
+		budget:       budget,
+		adminToken:   os.Getenv("ADMIN_TOKEN"),
+		logger:       logger,
+		http: httpConfig{
+			readTimeout:  time.Duration(intEnv("SERVER_READ_TIMEOUT", 10)) * time.Second,
+			writeTimeout: time.Duration(intEnv("SERVER_WRITE_TIMEOUT", 60)) * time.Second,
+			idleTimeout:  time.Duration(intEnv("SERVER_IDLE_TIMEOUT", 120)) * time.Second,
+			gracePeriod:  time.Duration(intEnv("SHUTDOWN_GRACE_PERIOD", 25)) * time.Second,
+		},
+	}
+
+	//weather endpoints require an API key when a keys file is set
+	if path := os.Getenv("API_KEYS_FILE"); path != "" {
+		keys, err := LoadAPIKeys(path)
+		if err != nil {
+			return nil, err
+		}
+		s.apiKeys = keys
+	}
+
+	//tracing is enabled when a collector is set
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		serviceName := os.Getenv("OTEL_SERVICE_NAME")
+		if serviceName == "" {
+			serviceName = "weatherapi"
+		}
+		exporter := tracing.NewOTLP(endpoint, serviceName, logger)
+		s.tracer = tracing.New(exporter)
+		s.OnShutdown("tracing", exporter.Shutdown)
+	}
+
+	//cache counters live in memory, so they are logged before they are lost
+	s.OnShutdown("cache stats", func(ctx context.Context) error {
+		for name, cache := range caches {
+			stats := cache.Stats(ctx)
+			logger.Info("Cache stats", "cache", name, "hits", stats.Hits, "misses", stats.Misses,
+				"evictions", stats.Evictions, "items", stats.Items)
+		}
+		return nil
+	})
+	for name, cache := range caches {
+		if closer, ok := cache.(io.Closer); ok {
+			s.OnShutdown(name, func(ctx context.Context) error {
+				return closer.Close()
+			})
+		}
+	}
 
 	registerRoutes(s)
-	return s
+	return s, nil
+}
+
+//intEnv returns the integer set in the env var name, or def when it is missing or not a positive integer
+func intEnv(name string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
+
+//Redis key prefixes of each cache, added after REDIS_PREFIX. Neither may be a prefix of the other, or scanning the
+//keys of a cache, to count or flush them, would also find the keys of the other one
+const (
+	cachePrefix         = "cache:"
+	lastKnownGoodPrefix = "lkg:"
+)
+
+//newCache returns the cache selected by CACHE_BACKEND, expiring after d minutes. Redis keys are
+//prefixed with REDIS_PREFIX and then with prefix
+func newCache(prefix string, d int, logger *logging.Logger) apicache.Cache {
+	if os.Getenv("CACHE_BACKEND") != "redis" {
+		return apicache.New(d)
+	}
+
+	redisPrefix := os.Getenv("REDIS_PREFIX")
+	if redisPrefix == "" {
+		redisPrefix = "weatherapi:"
+	}
+
+	return apicache.NewRedis(os.Getenv("REDIS_ADDR"), os.Getenv("REDIS_PASSWORD"), redisPrefix+prefix, d, logger)
 }
 
-func registerRoutes(s Server) {
+func registerRoutes(s *Server) {
+	s.Use(RequestID(), Trace(s.tracer), AccessLog(s.logger), RecordMetrics())
+
+	//health is kept for clients of the former health check, as the liveness check
 	s.Group("").GET("/health", HealthCheck)
+	s.Group("").GET("/health/live", HealthCheck)
+	s.Group("").GET("/health/ready", ReadinessCheck(s.probe, s.caches))
+	s.Group("").GET("/metrics", GetMetrics(s.caches))
+
+	api := s.Group("")
+	if s.apiKeys != nil {
+		api.Use(RequireAPIKey(s.apiKeys))
+	}
 
-	s.Group("").
+	api.Group("").
 		Use(ValidateRequest()).
 		GET("/weather", GetWeather(s.service))
+
+	api.Group("").
+		Use(ValidateOptions()).
+		POST("/weather/batch", GetWeatherBatch(s.service, s.batchWorkers))
+
+	api.Group("").
+		Use(ValidatePlace(), ValidateDays(), RejectParams("steps", "units", "format", "tz")).
+		GET("/forecast/daily", GetDailyForecast(s.service))
+
+	//the admin API is only available when an admin token is set
+	if s.adminToken != "" {
+		admin := s.Group("/admin").
+			Use(RequireAdminToken(s.adminToken)).
+			GET("/cache/stats", GetCacheStats(s.caches)).
+			DELETE("/cache/*key", DeleteCacheKey(s.caches)).
+			DELETE("/cache", FlushCache(s.caches))
+
+		if s.apiKeys != nil {
+			admin.GET("/keys", GetKeyUsage(s.apiKeys))
+		}
+		if s.budget != nil {
+			admin.GET("/budget", GetBudget(s.budget))
+		}
+	}
 }
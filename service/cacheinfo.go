@@ -0,0 +1,43 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/garciacer87/weatherAPI/tracing"
+)
+
+const (
+	//CacheMiss means the response was built from OpenWeather API
+	CacheMiss = "miss"
+	//CacheFresh means the response was served from the cache within its soft TTL
+	CacheFresh = "fresh"
+	//CacheStale means the response was served from the cache after its soft TTL, while it is refreshed
+	CacheStale = "stale"
+	//CacheLastKnownGood means OpenWeather API failed, and the last successful response was served instead
+	CacheLastKnownGood = "last-known-good"
+)
+
+//CacheInfo describes how a response was served
+type CacheInfo struct {
+	//Status is one of CacheMiss, CacheFresh, CacheStale or CacheLastKnownGood
+	Status string
+	//Age is how long ago the cached response was stored
+	Age time.Duration
+}
+
+type cacheInfoKey struct{}
+
+//WithCacheInfo returns a context used to record into info how the response was served
+func WithCacheInfo(ctx context.Context, info *CacheInfo) context.Context {
+	return context.WithValue(ctx, cacheInfoKey{}, info)
+}
+
+func recordCache(ctx context.Context, status string, age time.Duration) {
+	tracing.SpanFromContext(ctx).SetAttributes("cache.status", status)
+
+	if info, ok := ctx.Value(cacheInfoKey{}).(*CacheInfo); ok {
+		info.Status = status
+		info.Age = age
+	}
+}
@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/garciacer87/weatherAPI/tracing"
+)
+
+const (
+	//DefaultDays is the number of days returned by the daily forecast when none is requested
+	DefaultDays = 5
+	//MaxDays is the maximum number of days covered by OpenWeather 5 day forecast
+	MaxDays = 5
+)
+
+//GetDailyForecast gets the forecast of a city aggregated per day. state tells apart US cities sharing the same name,
+//and is empty otherwise. Uses a cache for retrieving response
+func (s *service) GetDailyForecast(ctx context.Context, city, state, country string, days int) (int, []byte) {
+	ctx, span := tracing.Start(ctx, "service.GetDailyForecast", tracing.KindInternal, "city", city, "state", state, "country", country)
+	defer span.End()
+
+	days = withDefaultDays(days)
+
+	respCode, finalResp := s.getDailyForecast(
+		ctx,
+		fmt.Sprintf("daily_%s_%d", getRequestID(city, state, country), days),
+		state,
+		days,
+		func(ctx context.Context) (int, []byte) {
+			return s.apiClient.GetForecast(ctx, city, state, country, s.unit, MaxSteps)
+		},
+	)
+
+	span.SetStatusCode(respCode)
+	return respCode, finalResp
+}
+
+//GetDailyForecastByCoord gets the forecast of geographic coordinates aggregated per day. Coordinates are rounded
+//so nearby lookups share the same cache entry
+func (s *service) GetDailyForecastByCoord(ctx context.Context, lat, lon float64, days int) (int, []byte) {
+	ctx, span := tracing.Start(ctx, "service.GetDailyForecastByCoord", tracing.KindInternal, "lat", lat, "lon", lon)
+	defer span.End()
+
+	days = withDefaultDays(days)
+	lat, lon = roundCoord(lat), roundCoord(lon)
+
+	respCode, finalResp := s.getDailyForecast(
+		ctx,
+		fmt.Sprintf("daily_%s_%d", getCoordRequestID(lat, lon), days),
+		"",
+		days,
+		func(ctx context.Context) (int, []byte) {
+			return s.apiClient.GetForecastByCoord(ctx, lat, lon, s.unit, MaxSteps)
+		},
+	)
+
+	span.SetStatusCode(respCode)
+	return respCode, finalResp
+}
+
+func (s *service) getDailyForecast(ctx context.Context, reqID, state string, days int, getForecast fetchFunc) (int, []byte) {
+	return s.cached(ctx, reqID, func(ctx context.Context) (int, []byte) {
+		respCode, forecastBody := getForecast(ctx)
+		if respCode != http.StatusOK {
+			return respCode, forecastBody
+		}
+
+		finalResp, err := buildDailyResponse(forecastBody, state, days, s.unit)
+		if err != nil {
+			s.logger.WithContext(ctx).Error("Error processing OpenWeather API response", "request", reqID, "error", err)
+			return http.StatusInternalServerError, []byte(`{"code":500, "message":"Error processing response"}`)
+		}
+
+		return respCode, finalResp
+	})
+}
+
+func withDefaultDays(days int) int {
+	if days <= 0 {
+		return DefaultDays
+	}
+	return days
+}
+
+//buildDailyResponse rolls the 3-hourly forecast list up into per day summaries. Days follow the city local time
+func buildDailyResponse(forecastBody []byte, state string, days int, unit string) ([]byte, error) {
+	var fcResp forecastResponse
+
+	err := json.Unmarshal(forecastBody, &fcResp)
+	if err != nil {
+		return nil, err
+	}
+
+	loc, _ := location(TZLocal, fcResp.City.Timezone)
+
+	type summary struct {
+		date          string
+		min           float64
+		max           float64
+		humidity      int
+		precipitation float64
+		count         int
+		clouds        map[string]int
+		cloudsOrder   []string
+	}
+
+	summaries := make([]*summary, 0)
+	for _, fcInfo := range fcResp.Forecast {
+		date := fmtDate(fcInfo.Dt, loc)
+
+		if len(summaries) == 0 || summaries[len(summaries)-1].date != date {
+			if len(summaries) == days {
+				break
+			}
+			summaries = append(summaries, &summary{
+				date:   date,
+				min:    math.Inf(1),
+				max:    math.Inf(-1),
+				clouds: make(map[string]int),
+			})
+		}
+
+		sm := summaries[len(summaries)-1]
+		sm.min = math.Min(sm.min, fcInfo.Main.TempMin)
+		sm.max = math.Max(sm.max, fcInfo.Main.TempMax)
+		sm.humidity += fcInfo.Main.Humidity
+		sm.precipitation += fcInfo.Rain.ThreeHours + fcInfo.Snow.ThreeHours
+		sm.count++
+
+		if len(fcInfo.Weather) > 0 {
+			description := fcInfo.Weather[0].Description
+			if sm.clouds[description] == 0 {
+				sm.cloudsOrder = append(sm.cloudsOrder, description)
+			}
+			sm.clouds[description]++
+		}
+	}
+
+	dailyList := make([]dailyForecast, 0)
+	for _, sm := range summaries {
+		dailyList = append(dailyList, dailyForecast{
+			Date:          sm.date,
+			Min:           fmtTemperature(sm.min, unit),
+			Max:           fmtTemperature(sm.max, unit),
+			Cloudiness:    dominant(sm.clouds, sm.cloudsOrder),
+			Humidity:      fmt.Sprintf("%.0f%%", float64(sm.humidity)/float64(sm.count)),
+			Precipitation: fmt.Sprintf("%.2f mm", sm.precipitation),
+		})
+	}
+
+	now := time.Now().In(loc)
+
+	r := DailyForecastResponse{
+		Location: fmtLocation(fcResp.City.Name, state, fcResp.City.Country),
+		ReqTime:  fmt.Sprintf("%02d:%02d", now.Hour(), now.Minute()),
+		Days:     dailyList,
+	}
+
+	finalResp, _ := json.Marshal(&r)
+
+	return finalResp, nil
+}
+
+//dominant returns the most frequent value. Ties are resolved in favour of the first value seen
+func dominant(counts map[string]int, order []string) string {
+	var result string
+	for _, v := range order {
+		if counts[v] > counts[result] {
+			result = v
+		}
+	}
+	return result
+}
+
+func fmtDate(timestamp int, loc *time.Location) string {
+	datetime := time.Unix(int64(timestamp), 0).In(loc)
+	return fmt.Sprintf("%02d/%02d/%02d", datetime.Day(), datetime.Month(), datetime.Year())
+}
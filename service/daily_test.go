@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/garciacer87/weatherAPI/apicache"
+)
+
+var dailyForecastResp = []byte(`{"cod":"200","message":0,"cnt":4,"list":[{"dt":1611565200,"main":{"temp":2.27,"feels_like":-3.25,"temp_min":2.27,"temp_max":2.71,"pressure":1004,"humidity":87},"weather":[{"id":803,"main":"Clouds","description":"broken clouds","icon":"04d"}],"rain":{"3h":0.5},"dt_txt":"2021-01-25 09:00:00"},{"dt":1611576000,"main":{"temp":4.1,"feels_like":-1.63,"temp_min":4.1,"temp_max":4.72,"pressure":1007,"humidity":73},"weather":[{"id":500,"main":"Rain","description":"light rain","icon":"10d"}],"rain":{"3h":1.25},"dt_txt":"2021-01-25 12:00:00"},{"dt":1611651600,"main":{"temp":6.1,"feels_like":3.2,"temp_min":5.8,"temp_max":6.3,"pressure":1010,"humidity":60},"weather":[{"id":800,"main":"Clear","description":"clear sky","icon":"01d"}],"dt_txt":"2021-01-26 09:00:00"},{"dt":1611662400,"main":{"temp":8.4,"feels_like":5.1,"temp_min":8.1,"temp_max":8.9,"pressure":1011,"humidity":50},"weather":[{"id":800,"main":"Clear","description":"clear sky","icon":"01d"}],"snow":{"3h":0.1},"dt_txt":"2021-01-26 12:00:00"}],"city":{"id":2988507,"name":"Paris","coord":{"lat":48.8534,"lon":2.3488},"country":"FR","timezone":3600}}`)
+
+func TestGetDailyForecast(t *testing.T) {
+	s := New(testConfig, apicache.New(2))
+
+	ms := s.(*service)
+	client := &mockService{}
+	ms.apiClient = client
+	cache := &mockCache{make(map[string][]byte)}
+	ms.cache = cache
+
+	tests := []struct {
+		name     string
+		params   params
+		expected int
+	}{
+		{"Succesful response", params{"Paris", "FR"}, 200},
+		{"Failed forecast response", params{"qwer", "zz"}, 404},
+		{"Successful response from cache", params{"Paris", "FR"}, 200},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			statusCode, _ := s.GetDailyForecast(context.Background(), test.params.city, "", test.params.country, 0)
+			if statusCode != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, statusCode, test.expected)
+			}
+		})
+	}
+
+	if client.cnt != MaxSteps {
+		t.Errorf("Error in forecast steps: Got: %d, Expected: %d", client.cnt, MaxSteps)
+	}
+
+	if cache.v["daily_paris_fr_5"] == nil {
+		t.Errorf("Expected daily forecast in cache")
+	}
+}
+
+func TestGetDailyForecastProcessingError(t *testing.T) {
+	s := New(testConfig, &noCache{})
+	s.(*service).apiClient = &mockService{}
+
+	//the mock client answers an empty body for unknown cities, which cannot be processed
+	statusCode, body := s.GetDailyForecast(context.Background(), "Atlantis", "", "FR", 0)
+	if statusCode != 500 {
+		t.Errorf("Unexpected status code. Got: %d, Expected: %d", statusCode, 500)
+	}
+	if !json.Valid(body) {
+		t.Errorf("Expected a valid JSON body. Got: %s", body)
+	}
+}
+
+func TestGetDailyForecastByCoord(t *testing.T) {
+	s := New(testConfig, apicache.New(2))
+
+	ms := s.(*service)
+	ms.apiClient = &mockService{}
+	ms.cache = &mockCache{make(map[string][]byte)}
+
+	statusCode, _ := s.GetDailyForecastByCoord(context.Background(), 48.8534, 2.3488, 3)
+	if statusCode != 200 {
+		t.Errorf("Unexpected status code. Got: %d, Expected: %d", statusCode, 200)
+	}
+
+	statusCode, _ = s.GetDailyForecastByCoord(context.Background(), 10, 10, 3)
+	if statusCode != 404 {
+		t.Errorf("Unexpected status code. Got: %d, Expected: %d", statusCode, 404)
+	}
+}
+
+func TestDailyRespBuilder(t *testing.T) {
+	var resp DailyForecastResponse
+
+	data, _ := buildDailyResponse(dailyForecastResp, "", 5, "metric")
+
+	json.Unmarshal(data, &resp)
+
+	if resp.Location != "Paris, FR" {
+		t.Errorf("Error in location: Got: %s, Expected: %s", resp.Location, "Paris, FR")
+	}
+
+	if len(resp.Days) != 2 {
+		t.Fatalf("Error in days list size: Got: %d, Expected: %d", len(resp.Days), 2)
+	}
+
+	expected := []dailyForecast{
+		{"25/01/2021", "2ºC", "5ºC", "broken clouds", "80%", "1.75 mm"},
+		{"26/01/2021", "6ºC", "9ºC", "clear sky", "55%", "0.10 mm"},
+	}
+	for i, day := range resp.Days {
+		if day != expected[i] {
+			t.Errorf("Error in day %d: Got: %+v, Expected: %+v", i, day, expected[i])
+		}
+	}
+
+	data, _ = buildDailyResponse(dailyForecastResp, "", 1, "metric")
+	json.Unmarshal(data, &resp)
+
+	if len(resp.Days) != 1 {
+		t.Errorf("Error in days list size: Got: %d, Expected: %d", len(resp.Days), 1)
+	}
+
+	_, err := buildDailyResponse([]byte(""), "", 5, "metric")
+	if err == nil {
+		t.Errorf("Expected error ")
+	}
+}
+
+func TestDominant(t *testing.T) {
+	counts := map[string]int{"clear sky": 2, "light rain": 2, "broken clouds": 1}
+
+	v := dominant(counts, []string{"broken clouds", "light rain", "clear sky"})
+	if v != "light rain" {
+		t.Errorf("Dominant value is different than expected. Got: %s, Expected: %s", v, "light rain")
+	}
+}
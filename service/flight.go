@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//flightGroup deduplicates concurrent calls sharing the same key, so they share a single upstream round-trip
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done chan struct{}
+	code int
+	body []byte
+
+	//waiters are the callers still waiting for the flight. When the last one goes away, cancel stops fn
+	waiters int
+	cancel  context.CancelFunc
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flight)}
+}
+
+//do runs fn once for all concurrent callers of key. Each caller stops waiting when its ctx is done, and a caller
+//going away does not fail the others. fn is only cancelled once every caller went away
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (int, []byte)) (int, []byte) {
+	g.mu.Lock()
+	f, ok := g.calls[key]
+	if ok {
+		f.waiters++
+	} else {
+		fctx, cancel := context.WithCancel(detachedContext{ctx})
+		f = &flight{done: make(chan struct{}), waiters: 1, cancel: cancel}
+		g.calls[key] = f
+
+		go func() {
+			f.code, f.body = fn(fctx)
+
+			g.mu.Lock()
+			g.forget(key, f)
+			g.mu.Unlock()
+
+			cancel()
+			close(f.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.code, f.body
+	case <-ctx.Done():
+		g.leave(key, f)
+		if ctx.Err() == context.DeadlineExceeded {
+			return http.StatusGatewayTimeout, []byte(`{"code":504, "message":"Timeout waiting for weather information"}`)
+		}
+		return http.StatusServiceUnavailable, []byte(`{"code":503, "message":"Request cancelled"}`)
+	}
+}
+
+//leave removes a caller that stopped waiting for f. When it was the last one, fn is cancelled and later callers
+//of key start a new flight
+func (g *flightGroup) leave(key string, f *flight) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	f.waiters--
+	if f.waiters == 0 {
+		g.forget(key, f)
+		f.cancel()
+	}
+}
+
+//forget removes f from the calls in flight, unless another flight of key already replaced it
+func (g *flightGroup) forget(key string, f *flight) {
+	if g.calls[key] == f {
+		delete(g.calls, key)
+	}
+}
+
+//detachedContext keeps the values of its parent but is never cancelled
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
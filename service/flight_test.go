@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/garciacer87/weatherAPI/apicache"
+)
+
+//countingClient counts the calls reaching the upstream
+type countingClient struct {
+	slowClient
+	weatherCalls  int32
+	forecastCalls int32
+}
+
+func (cc *countingClient) GetWeather(ctx context.Context, city, state, country, unit string) (int, []byte) {
+	atomic.AddInt32(&cc.weatherCalls, 1)
+	return cc.slowClient.GetWeather(ctx, city, state, country, unit)
+}
+
+func (cc *countingClient) GetForecast(ctx context.Context, city, state, country, unit string, cnt int) (int, []byte) {
+	atomic.AddInt32(&cc.forecastCalls, 1)
+	return cc.slowClient.GetForecast(ctx, city, state, country, unit, cnt)
+}
+
+func TestGetWeatherCoalescesMisses(t *testing.T) {
+	s := New(testConfig, apicache.New(2))
+
+	client := &countingClient{slowClient: slowClient{50 * time.Millisecond}}
+	ms := s.(*service)
+	ms.apiClient = client
+	ms.cache = &noCache{}
+
+	requests := 50
+	codes := make([]int, requests)
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			codes[i], _ = s.GetWeather(context.Background(), "Paris", "", "FR", Options{})
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != 200 {
+			t.Errorf("Unexpected status code in request %d. Got: %d, Expected: %d", i, code, 200)
+		}
+	}
+
+	if calls := atomic.LoadInt32(&client.weatherCalls); calls != 1 {
+		t.Errorf("Error in weather calls: Got: %d, Expected: %d", calls, 1)
+	}
+
+	if calls := atomic.LoadInt32(&client.forecastCalls); calls != 1 {
+		t.Errorf("Error in forecast calls: Got: %d, Expected: %d", calls, 1)
+	}
+}
+
+func TestFlightGroupCallerCancelled(t *testing.T) {
+	g := newFlightGroup()
+	release := make(chan struct{})
+
+	fn := func(ctx context.Context) (int, []byte) {
+		<-release
+		if ctx.Err() != nil {
+			return 503, nil
+		}
+		return 200, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan int)
+	go func() {
+		code, _ := g.do(context.Background(), "paris_fr", fn)
+		result <- code
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	code, _ := g.do(ctx, "paris_fr", fn)
+	if code != 503 {
+		t.Errorf("Unexpected status code for cancelled caller. Got: %d, Expected: %d", code, 503)
+	}
+
+	close(release)
+	if code := <-result; code != 200 {
+		t.Errorf("Unexpected status code for remaining caller. Got: %d, Expected: %d", code, 200)
+	}
+
+	//once every caller went away, fn is cancelled
+	cancelled := make(chan struct{})
+	fn = func(ctx context.Context) (int, []byte) {
+		<-ctx.Done()
+		close(cancelled)
+		return 503, nil
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	go g.do(ctx1, "london_gb", fn)
+	go g.do(ctx2, "london_gb", fn)
+
+	time.Sleep(10 * time.Millisecond)
+	cancel1()
+	select {
+	case <-cancelled:
+		t.Errorf("fn must not be cancelled while a caller is waiting")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancel2()
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Errorf("fn must be cancelled once every caller went away")
+	}
+}
+
+func TestDetachedContext(t *testing.T) {
+	type key struct{}
+
+	parent, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "value"))
+	cancel()
+
+	ctx := detachedContext{parent}
+	if ctx.Err() != nil {
+		t.Errorf("Detached context must not be cancelled. Got: %v", ctx.Err())
+	}
+
+	if ctx.Value(key{}) != "value" {
+		t.Errorf("Detached context must keep values. Got: %v, Expected: %v", ctx.Value(key{}), "value")
+	}
+}
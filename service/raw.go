@@ -0,0 +1,72 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+)
+
+//buildRawResponse builds the final response keeping values as numbers. Times are RFC 3339 timestamps
+func buildRawResponse(weatherBody, forecastBody []byte, state, unit, tz string) ([]byte, error) {
+	wResp, fcResp, err := parseResponses(weatherBody, forecastBody)
+	if err != nil {
+		return nil, err
+	}
+
+	loc, err := location(tz, wResp.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	forecastList := make([]rawForecast, 0)
+	for _, fcInfo := range fcResp.Forecast {
+		forecastList = append(forecastList, rawForecast{
+			ForecastedDate: fmtTimestamp(fcInfo.Dt, loc),
+			Temp:           fcInfo.Main.Temp,
+			Feel:           fcInfo.Main.FeelsLike,
+			Min:            fcInfo.Main.TempMin,
+			Max:            fcInfo.Main.TempMax,
+			Cloudiness:     fcInfo.Weather[0].Description,
+			Humidity:       fcInfo.Main.Humidity,
+		})
+	}
+
+	r := RawResponse{
+		Meta: rawMeta{
+			Unit:        unit,
+			Temperature: units[unit].temp,
+			Speed:       units[unit].speed,
+			Pressure:    "hpa",
+			Humidity:    "%",
+			ReqTime:     time.Now().In(loc).Format(time.RFC3339),
+		},
+		Location: rawLocation{
+			Name:    wResp.Name,
+			State:   state,
+			Country: wResp.Sys.Country,
+			Coord:   rawCoord{wResp.Coord.Lat, wResp.Coord.Lon},
+		},
+		Temp: wResp.Main.Temp,
+		Feel: wResp.Main.FeelsLike,
+		Min:  wResp.Main.TempMin,
+		Max:  wResp.Main.TempMax,
+		Wind: rawWind{
+			Speed:     wResp.Wind.Speed,
+			Deg:       wResp.Wind.Deg,
+			Direction: getWindDirection(wResp.Wind.Deg),
+		},
+		Cloudiness: wResp.Weather[0].Description,
+		Pressure:   wResp.Main.Pressure,
+		Humidity:   wResp.Main.Humidity,
+		Sunrise:    fmtTimestamp(wResp.Sys.Sunrise, loc),
+		Sunset:     fmtTimestamp(wResp.Sys.Sunset, loc),
+		Forecast:   forecastList,
+	}
+
+	finalResp, _ := json.Marshal(&r)
+
+	return finalResp, nil
+}
+
+func fmtTimestamp(timestamp int, loc *time.Location) string {
+	return time.Unix(int64(timestamp), 0).In(loc).Format(time.RFC3339)
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRawRespBuilder(t *testing.T) {
+	var resp RawResponse
+
+	data, _ := buildRawResponse(weatherResp, forecastResp, "", "imperial", TZUTC)
+
+	json.Unmarshal(data, &resp)
+
+	if resp.Temp != 1.85 {
+		t.Errorf("Error in temperature: Got: %v, Expected: %v", resp.Temp, 1.85)
+	}
+
+	if resp.Meta.Unit != "imperial" || resp.Meta.Temperature != "ºF" || resp.Meta.Speed != "miles/hr" {
+		t.Errorf("Error in metadata: Got: %+v", resp.Meta)
+	}
+
+	if resp.Wind.Speed != 7.2 || resp.Wind.Deg != 290 {
+		t.Errorf("Error in wind: Got: %+v, Expected: %v and %v", resp.Wind, 7.2, 290)
+	}
+
+	if resp.Location.Coord.Lat != 48.8534 || resp.Location.Coord.Lon != 2.3488 {
+		t.Errorf("Error in coordinates: Got: %+v", resp.Location.Coord)
+	}
+
+	if resp.Location.State != "" {
+		t.Errorf("Error in state: Got: %s, Expected no state", resp.Location.State)
+	}
+
+	data, _ = buildRawResponse(weatherResp, forecastResp, "IL", "imperial", TZUTC)
+	json.Unmarshal(data, &resp)
+
+	if resp.Location.State != "IL" {
+		t.Errorf("Error in state: Got: %s, Expected: %s", resp.Location.State, "IL")
+	}
+
+	if resp.Sunrise != "2021-01-25T07:29:23Z" {
+		t.Errorf("Error in sunrise: Got: %s, Expected: %s", resp.Sunrise, "2021-01-25T07:29:23Z")
+	}
+
+	if len(resp.Forecast) != 2 {
+		t.Errorf("Error in forecast list size: Got: %d, Expected: %d", len(resp.Forecast), 2)
+	}
+
+	data, _ = buildRawResponse(weatherResp, forecastResp, "", "imperial", TZLocal)
+	json.Unmarshal(data, &resp)
+
+	if resp.Sunrise != "2021-01-25T08:29:23+01:00" {
+		t.Errorf("Error in sunrise: Got: %s, Expected: %s", resp.Sunrise, "2021-01-25T08:29:23+01:00")
+	}
+
+	_, err := buildRawResponse(weatherResp, []byte(""), "", "metric", TZUTC)
+	if err == nil {
+		t.Errorf("Expected error ")
+	}
+}
+
+func TestFmtTimestamp(t *testing.T) {
+	ts := fmtTimestamp(1611558107, time.UTC)
+	if ts != "2021-01-25T07:01:47Z" {
+		t.Errorf("Timestamp is different than expected. Got: %s, Expected: %s", ts, "2021-01-25T07:01:47Z")
+	}
+}
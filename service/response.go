@@ -28,6 +28,77 @@ type forecast struct {
 	Humidity       string `json:"humidity"`
 }
 
+//RawResponse type used to represent final response with typed numeric values
+type RawResponse struct {
+	Meta       rawMeta       `json:"meta"`
+	Location   rawLocation   `json:"location"`
+	Temp       float64       `json:"temperature"`
+	Feel       float64       `json:"real_feel_temperature"`
+	Min        float64       `json:"minimum_temperature"`
+	Max        float64       `json:"maximum_temperature"`
+	Wind       rawWind       `json:"wind"`
+	Cloudiness string        `json:"cloudiness"`
+	Pressure   int           `json:"pressure"`
+	Humidity   int           `json:"humidity"`
+	Sunrise    string        `json:"sunrise"`
+	Sunset     string        `json:"sunset"`
+	Forecast   []rawForecast `json:"forecast"`
+}
+
+type rawMeta struct {
+	Unit        string `json:"unit"`
+	Temperature string `json:"temperature_unit"`
+	Speed       string `json:"speed_unit"`
+	Pressure    string `json:"pressure_unit"`
+	Humidity    string `json:"humidity_unit"`
+	ReqTime     string `json:"requested_time"`
+}
+
+type rawLocation struct {
+	Name string `json:"name"`
+	//State is the US state requested, empty for other countries
+	State   string   `json:"state,omitempty"`
+	Country string   `json:"country"`
+	Coord   rawCoord `json:"geo_coordinates"`
+}
+
+type rawCoord struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+type rawWind struct {
+	Speed     float64 `json:"speed"`
+	Deg       int     `json:"degrees"`
+	Direction string  `json:"direction"`
+}
+
+type rawForecast struct {
+	ForecastedDate string  `json:"forecasted_datetime"`
+	Temp           float64 `json:"temperature"`
+	Feel           float64 `json:"real_feel_temperature"`
+	Min            float64 `json:"minimum_temperature"`
+	Max            float64 `json:"maximum_temperature"`
+	Cloudiness     string  `json:"cloudiness"`
+	Humidity       int     `json:"humidity"`
+}
+
+//DailyForecastResponse type used to represent the daily aggregated forecast response
+type DailyForecastResponse struct {
+	Location string          `json:"location_name"`
+	ReqTime  string          `json:"requested_time"`
+	Days     []dailyForecast `json:"days"`
+}
+
+type dailyForecast struct {
+	Date          string `json:"date"`
+	Min           string `json:"minimum_temperature"`
+	Max           string `json:"maximum_temperature"`
+	Cloudiness    string `json:"cloudiness"`
+	Humidity      string `json:"average_humidity"`
+	Precipitation string `json:"total_precipitation"`
+}
+
 type mainWeatherInfo struct {
 	FeelsLike float64 `json:"feels_like"`
 	Humidity  int     `json:"humidity"`
@@ -46,9 +117,10 @@ type weatherResponse struct {
 		Lat float64 `json:"lat"`
 		Lon float64 `json:"lon"`
 	} `json:"coord"`
-	Main mainWeatherInfo `json:"main"`
-	Name string          `json:"name"`
-	Sys  struct {
+	Main     mainWeatherInfo `json:"main"`
+	Name     string          `json:"name"`
+	Timezone int             `json:"timezone"`
+	Sys      struct {
 		Country string `json:"country"`
 		Sunrise int    `json:"sunrise"`
 		Sunset  int    `json:"sunset"`
@@ -60,10 +132,21 @@ type weatherResponse struct {
 	} `json:"wind"`
 }
 
+type precipitationInfo struct {
+	ThreeHours float64 `json:"3h"`
+}
+
 type forecastResponse struct {
 	Forecast []struct {
-		Dt      int             `json:"dt"`
-		Main    mainWeatherInfo `json:"main"`
-		Weather []cloudInfo     `json:"weather"`
+		Dt      int               `json:"dt"`
+		Main    mainWeatherInfo   `json:"main"`
+		Weather []cloudInfo       `json:"weather"`
+		Rain    precipitationInfo `json:"rain"`
+		Snow    precipitationInfo `json:"snow"`
 	} `json:"list"`
+	City struct {
+		Name     string `json:"name"`
+		Country  string `json:"country"`
+		Timezone int    `json:"timezone"`
+	} `json:"city"`
 }
@@ -1,14 +1,19 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/garciacer87/weatherAPI/apicache"
+	"github.com/garciacer87/weatherAPI/logging"
 	"github.com/garciacer87/weatherAPI/openweather"
+	"github.com/garciacer87/weatherAPI/tracing"
 )
 
 var (
@@ -18,8 +23,11 @@ var (
 	}{
 		"metric":   {"ºC", "m/s"},
 		"imperial": {"ºF", "miles/hr"},
+		"standard": {"K", "m/s"},
 	}
 
+	formats = []string{FormatText, FormatRaw}
+
 	windDirections = map[int]string{
 		0:   "North",
 		45:  "NorthEast",
@@ -32,74 +40,364 @@ var (
 	}
 )
 
+const (
+	//DefaultSteps is the number of forecast entries returned when none is requested
+	DefaultSteps = 3
+	//MaxSteps is the maximum number of forecast entries (3 hours each) OpenWeather 5 day forecast returns
+	MaxSteps = 40
+
+	//FormatText formats every value as human readable text
+	FormatText = "text"
+	//FormatRaw returns typed numeric values, declaring the unit once in the response metadata
+	FormatRaw = "raw"
+
+	//TZLocal renders times in the city local time, using the UTC offset returned by OpenWeather
+	TZLocal = "local"
+	//TZUTC renders times in UTC
+	TZUTC = "utc"
+)
+
 //Service interface used to implement "get weather" logic
 type Service interface {
-	GetWeather(city, country string) (int, []byte)
+	GetWeather(ctx context.Context, city, state, country string, opts Options) (int, []byte)
+	GetWeatherByCoord(ctx context.Context, lat, lon float64, opts Options) (int, []byte)
+	GetDailyForecast(ctx context.Context, city, state, country string, days int) (int, []byte)
+	GetDailyForecastByCoord(ctx context.Context, lat, lon float64, days int) (int, []byte)
+}
+
+//Options holds per request settings used to build a weather response
+type Options struct {
+	//Steps is the number of forecast entries (3 hours each) to return
+	Steps int
+	//Unit is the unit measurement: metric, imperial or standard. Empty means the service default
+	Unit string
+	//Format is the response format: text or raw. Empty means text
+	Format string
+	//TZ is the timezone used to render times: local, utc or an IANA name. Empty means local
+	TZ string
+}
+
+func (o Options) withDefaults(unit string) Options {
+	if o.Steps <= 0 {
+		o.Steps = DefaultSteps
+	}
+	if o.Unit == "" {
+		o.Unit = unit
+	}
+	if o.Format == "" {
+		o.Format = FormatText
+	}
+	if o.TZ == "" {
+		o.TZ = TZLocal
+	}
+	return o
+}
+
+//requestID appends the options to a location request id, so different options never share a cache entry
+func (o Options) requestID(id string) string {
+	return fmt.Sprintf("%s_%d_%s_%s_%s", id, o.Steps, o.Unit, o.Format, o.TZ)
+}
+
+//ValidUnit reports whether unit is a supported unit measurement
+func ValidUnit(unit string) bool {
+	_, ok := units[unit]
+	return ok
+}
+
+//ValidFormat reports whether format is a supported response format
+func ValidFormat(format string) bool {
+	for _, f := range formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+//Formats returns the supported response formats
+func Formats() []string {
+	return append([]string(nil), formats...)
+}
+
+//ValidTZ reports whether tz is local, utc or a known IANA timezone name
+func ValidTZ(tz string) bool {
+	if tz == TZLocal || tz == TZUTC {
+		return true
+	}
+	if tz == "" || tz == "Local" {
+		return false
+	}
+
+	_, err := time.LoadLocation(tz)
+	return err == nil
+}
+
+//Units returns the supported unit measurements
+func Units() []string {
+	names := make([]string, 0, len(units))
+	for name := range units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Config holds the settings used to build a Service
+type Config struct {
+	//Client calls OpenWeather API. When nil, a client is created with Host and APIKey
+	Client openweather.Client
+	//Host, APIKey and Unit are used to connect to OpenWeather API
+	Host   string
+	APIKey string
+	Unit   string
+	//Timeout bounds every call to OpenWeather API
+	Timeout time.Duration
+	//SoftTTL is how long a cached response is fresh. Once exceeded, it is still served (stale)
+	//while it is refreshed in the background, until the cache drops it. Zero means always fresh
+	SoftTTL time.Duration
+	//Budget limits the calls to OpenWeather API. While it is under pressure, stale responses are served without
+	//being refreshed, and the last known good response is served instead of calling the API. Nil does not limit calls
+	Budget *openweather.Budget
+	//LastKnownGood keeps every successful response, to be served when OpenWeather API fails.
+	//Its expiration sets the max staleness. Nil disables it
+	LastKnownGood apicache.Cache
+	//Logger gets errors from OpenWeather API and from processing its responses. Nil discards them
+	Logger *logging.Logger
 }
 
 type service struct {
 	apiClient openweather.Client
 	unit      string
 	cache     apicache.Cache
+	flights   *flightGroup
+	softTTL   time.Duration
+	lastGood  apicache.Cache
+	budget    *openweather.Budget
+	logger    *logging.Logger
 }
 
-//New returns a new Service
-func New(host, apiKey, unit string, cacheDuration int) Service {
-	apiClient := openweather.NewClient(host, apiKey, unit)
-	cache := apicache.New(cacheDuration)
+//New returns a new Service storing responses in cache
+func New(cfg Config, cache apicache.Cache) Service {
+	apiClient := cfg.Client
+	if apiClient == nil {
+		apiClient = openweather.NewClient(cfg.Host, cfg.APIKey, cfg.Unit, cfg.Timeout, cfg.Budget, cfg.Logger)
+	}
+	logger := cfg.Logger.With("component", "service")
 
-	return &service{apiClient, unit, cache}
+	return &service{apiClient, cfg.Unit, cache, newFlightGroup(), cfg.SoftTTL, cfg.LastKnownGood, cfg.Budget, logger}
 }
 
-//GetWeather gets weather information from a city. Uses a cache for retrieving response
-func (s *service) GetWeather(city, country string) (int, []byte) {
-	reqID := getRequestID(city, country)
+//GetWeather gets weather information from a city. state tells apart US cities sharing the same name, and is
+//empty otherwise. Uses a cache for retrieving response
+func (s *service) GetWeather(ctx context.Context, city, state, country string, opts Options) (int, []byte) {
+	ctx, span := tracing.Start(ctx, "service.GetWeather", tracing.KindInternal, "city", city, "state", state, "country", country)
+	defer span.End()
+
+	opts = opts.withDefaults(s.unit)
+
+	respCode, finalResp := s.getWeather(
+		ctx,
+		getRequestID(city, state, country),
+		state,
+		opts,
+		func(ctx context.Context) (int, []byte) {
+			return s.apiClient.GetWeather(ctx, city, state, country, opts.Unit)
+		},
+		func(ctx context.Context) (int, []byte) {
+			return s.apiClient.GetForecast(ctx, city, state, country, opts.Unit, opts.Steps)
+		},
+	)
+
+	span.SetStatusCode(respCode)
+	return respCode, finalResp
+}
+
+//GetWeatherByCoord gets weather information from geographic coordinates. Coordinates are rounded
+//so nearby lookups share the same cache entry
+func (s *service) GetWeatherByCoord(ctx context.Context, lat, lon float64, opts Options) (int, []byte) {
+	ctx, span := tracing.Start(ctx, "service.GetWeatherByCoord", tracing.KindInternal, "lat", lat, "lon", lon)
+	defer span.End()
+
+	opts = opts.withDefaults(s.unit)
+	lat, lon = roundCoord(lat), roundCoord(lon)
+
+	respCode, finalResp := s.getWeather(
+		ctx,
+		getCoordRequestID(lat, lon),
+		"",
+		opts,
+		func(ctx context.Context) (int, []byte) {
+			return s.apiClient.GetWeatherByCoord(ctx, lat, lon, opts.Unit)
+		},
+		func(ctx context.Context) (int, []byte) {
+			return s.apiClient.GetForecastByCoord(ctx, lat, lon, opts.Unit, opts.Steps)
+		},
+	)
+
+	span.SetStatusCode(respCode)
+	return respCode, finalResp
+}
+
+func (s *service) getWeather(ctx context.Context, locationID, state string, opts Options, getWeather, getForecast fetchFunc) (int, []byte) {
+	reqID := opts.requestID(locationID)
+
+	return s.cached(ctx, reqID, func(ctx context.Context) (int, []byte) {
+		var weatherBody, forecastBody []byte
+
+		//both calls run in parallel. If one fails, the other one is cancelled
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		weatherCh, forecastCh := fetch(ctx, getWeather), fetch(ctx, getForecast)
+		for weatherCh != nil || forecastCh != nil {
+			select {
+			case r := <-weatherCh:
+				if r.code != http.StatusOK {
+					return r.code, r.body
+				}
+				weatherBody, weatherCh = r.body, nil
+			case r := <-forecastCh:
+				if r.code != http.StatusOK {
+					return r.code, r.body
+				}
+				forecastBody, forecastCh = r.body, nil
+			}
+		}
+
+		build := buildResponse
+		if opts.Format == FormatRaw {
+			build = buildRawResponse
+		}
+
+		finalResp, err := build(weatherBody, forecastBody, state, opts.Unit, opts.TZ)
+		if err != nil {
+			s.logger.WithContext(ctx).Error("Error processing OpenWeather API response", "request", reqID, "error", err)
+			return http.StatusInternalServerError, []byte(`{"code":500, "message":"Error processing response"}`)
+		}
 
-	finalResp := s.cache.GetValue(reqID)
+		return http.StatusOK, finalResp
+	})
+}
+
+//fetchFunc makes a call to OpenWeather API bound to ctx
+type fetchFunc func(ctx context.Context) (int, []byte)
+
+type fetchResult struct {
+	code int
+	body []byte
+}
+
+//fetch runs f in a new goroutine. The channel is buffered, so an abandoned result never blocks the goroutine
+func fetch(ctx context.Context, f fetchFunc) <-chan fetchResult {
+	ch := make(chan fetchResult, 1)
+	go func() {
+		code, body := f(ctx)
+		ch <- fetchResult{code, body}
+	}()
+	return ch
+}
+
+//cached returns the response stored under reqID. Otherwise, builds it and stores it when successful.
+//Concurrent misses for the same reqID share a single build. Stale responses are served while they are
+//refreshed in the background. When the build fails upstream, the last known good response is served.
+//While the call budget is under pressure, stale responses are not refreshed and the last known good
+//response is served without building a new one
+func (s *service) cached(ctx context.Context, reqID string, build func(ctx context.Context) (int, []byte)) (int, []byte) {
+	refresh := func(ctx context.Context) (int, []byte) {
+		respCode, finalResp := build(ctx)
+		if respCode == http.StatusOK {
+			s.cache.SetValue(ctx, reqID, finalResp)
+			if s.lastGood != nil {
+				s.lastGood.SetValue(ctx, reqID, finalResp)
+			}
+		}
+
+		return respCode, finalResp
+	}
+
+	finalResp, storedAt := lookup(ctx, s.cache, "cache", reqID)
 	if finalResp != nil {
+		age := time.Since(storedAt)
+		if s.softTTL > 0 && age > s.softTTL {
+			recordCache(ctx, CacheStale, age)
+			if s.budget.UnderPressure() {
+				return http.StatusOK, finalResp
+			}
+			//the refresh is detached, so it outlives the request that found the stale entry and keeps its flight alive
+			go func() {
+				if respCode, _ := s.flights.do(detachedContext{ctx}, reqID, refresh); respCode != http.StatusOK {
+					s.logger.WithContext(ctx).Warn("Error refreshing stale response", "request", reqID, "code", respCode)
+				}
+			}()
+			return http.StatusOK, finalResp
+		}
+
+		recordCache(ctx, CacheFresh, age)
 		return http.StatusOK, finalResp
 	}
 
-	respCode, weatherBody := s.apiClient.GetWeather(city, country)
-	if respCode != http.StatusOK {
-		return respCode, weatherBody
+	if s.budget.UnderPressure() {
+		if lastResp, ok := s.lastKnownGood(ctx, reqID, "call budget under pressure"); ok {
+			return http.StatusOK, lastResp
+		}
 	}
 
-	respCode, forecastBody := s.apiClient.GetForecast(city, country)
-	if respCode != http.StatusOK {
-		return respCode, forecastBody
+	recordCache(ctx, CacheMiss, 0)
+	respCode, finalResp := s.flights.do(ctx, reqID, refresh)
+
+	if respCode >= http.StatusInternalServerError {
+		if lastResp, ok := s.lastKnownGood(ctx, reqID, http.StatusText(respCode)); ok {
+			return http.StatusOK, lastResp
+		}
 	}
 
-	finalResp, err := buildResponse(weatherBody, forecastBody, s.unit)
-	if err != nil {
-		return http.StatusInternalServerError, []byte(`{"code":500, "message":"Error processing response"`)
+	return respCode, finalResp
+}
+
+//lastKnownGood returns the last known good response stored under reqID, if any, logging why it is served
+func (s *service) lastKnownGood(ctx context.Context, reqID, reason string) ([]byte, bool) {
+	if s.lastGood == nil {
+		return nil, false
 	}
 
-	s.cache.SetValue(reqID, finalResp)
+	lastResp, storedAt := lookup(ctx, s.lastGood, "last_known_good", reqID)
+	if lastResp == nil {
+		return nil, false
+	}
 
-	return respCode, finalResp
+	s.logger.WithContext(ctx).Warn("Serving last known good response", "request", reqID, "reason", reason, "age", time.Since(storedAt))
+	recordCache(ctx, CacheLastKnownGood, time.Since(storedAt))
+	return lastResp, true
 }
 
-func buildResponse(weatherBody, forecastBody []byte, unit string) ([]byte, error) {
-	var wResp weatherResponse
-	var fcResp forecastResponse
+//lookup gets the response stored under reqID in c, within a span telling whether it was found
+func lookup(ctx context.Context, c apicache.Cache, name, reqID string) ([]byte, time.Time) {
+	ctx, span := tracing.Start(ctx, "cache.lookup", tracing.KindInternal, "cache.name", name, "cache.key", reqID)
+	defer span.End()
 
-	err := json.Unmarshal(weatherBody, &wResp)
+	finalResp, storedAt := c.GetValue(ctx, reqID)
+	span.SetAttributes("cache.hit", finalResp != nil)
+
+	return finalResp, storedAt
+}
+
+func buildResponse(weatherBody, forecastBody []byte, state, unit, tz string) ([]byte, error) {
+	wResp, fcResp, err := parseResponses(weatherBody, forecastBody)
 	if err != nil {
 		return nil, err
 	}
 
-	err = json.Unmarshal(forecastBody, &fcResp)
+	loc, err := location(tz, wResp.Timezone)
 	if err != nil {
 		return nil, err
 	}
 
-	now := time.Now()
+	now := time.Now().In(loc)
 
 	forecastList := make([]forecast, 0)
 	for _, fcInfo := range fcResp.Forecast {
 		forecastList = append(forecastList, forecast{
-			ForecastedDate: fmtDateTime(fcInfo.Dt),
+			ForecastedDate: fmtDateTime(fcInfo.Dt, loc),
 			Temp:           fmtTemperature(fcInfo.Main.Temp, unit),
 			Feel:           fmtTemperature(fcInfo.Main.FeelsLike, unit),
 			Min:            fmtTemperature(fcInfo.Main.TempMin, unit),
@@ -110,7 +408,7 @@ func buildResponse(weatherBody, forecastBody []byte, unit string) ([]byte, error
 	}
 
 	r := Response{
-		Location:   fmt.Sprintf("%s, %s", wResp.Name, wResp.Sys.Country),
+		Location:   fmtLocation(wResp.Name, state, wResp.Sys.Country),
 		Temp:       fmtTemperature(wResp.Main.Temp, unit),
 		Feel:       fmtTemperature(wResp.Main.FeelsLike, unit),
 		Min:        fmtTemperature(wResp.Main.TempMin, unit),
@@ -119,8 +417,8 @@ func buildResponse(weatherBody, forecastBody []byte, unit string) ([]byte, error
 		Cloudiness: wResp.Weather[0].Description,
 		Pressure:   fmt.Sprintf("%v hpa", wResp.Main.Pressure),
 		Humidity:   fmt.Sprintf("%v%%", wResp.Main.Humidity),
-		Sunrise:    fmtTime(wResp.Sys.Sunrise),
-		Sunset:     fmtTime(wResp.Sys.Sunset),
+		Sunrise:    fmtTime(wResp.Sys.Sunrise, loc),
+		Sunset:     fmtTime(wResp.Sys.Sunset, loc),
 		Coord:      fmt.Sprintf("[%f, %f]", wResp.Coord.Lat, wResp.Coord.Lon),
 		ReqTime:    fmt.Sprintf("%02d:%02d", now.Hour(), now.Minute()),
 		Forecast:   forecastList,
@@ -131,21 +429,67 @@ func buildResponse(weatherBody, forecastBody []byte, unit string) ([]byte, error
 	return finalResp, nil
 }
 
-func getRequestID(city, country string) string {
+func parseResponses(weatherBody, forecastBody []byte) (weatherResponse, forecastResponse, error) {
+	var wResp weatherResponse
+	var fcResp forecastResponse
+
+	err := json.Unmarshal(weatherBody, &wResp)
+	if err != nil {
+		return wResp, fcResp, err
+	}
+
+	err = json.Unmarshal(forecastBody, &fcResp)
+
+	return wResp, fcResp, err
+}
+
+func getRequestID(city, state, country string) string {
+	if state != "" {
+		return strings.ToLower(fmt.Sprintf("%s_%s_%s", city, state, country))
+	}
 	return strings.ToLower(fmt.Sprintf("%s_%s", city, country))
 }
 
+//fmtLocation formats a location like "Paris, FR", or "Springfield, IL, US" when the state was requested
+func fmtLocation(name, state, country string) string {
+	if state != "" {
+		return fmt.Sprintf("%s, %s, %s", name, state, country)
+	}
+	return fmt.Sprintf("%s, %s", name, country)
+}
+
+func getCoordRequestID(lat, lon float64) string {
+	return fmt.Sprintf("%.2f_%.2f", lat, lon)
+}
+
+//roundCoord rounds a coordinate to 2 decimals (roughly 1km)
+func roundCoord(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
 func fmtTemperature(temp float64, unit string) string {
 	return fmt.Sprintf("%.0f%s", temp, units[unit].temp)
 }
 
-func fmtTime(timestamp int) string {
-	datetime := time.Unix(int64(timestamp), 0)
+//location returns the timezone used to render times. offset is the city UTC offset in seconds
+func location(tz string, offset int) (*time.Location, error) {
+	switch tz {
+	case TZLocal:
+		return time.FixedZone("", offset), nil
+	case TZUTC:
+		return time.UTC, nil
+	}
+
+	return time.LoadLocation(tz)
+}
+
+func fmtTime(timestamp int, loc *time.Location) string {
+	datetime := time.Unix(int64(timestamp), 0).In(loc)
 	return fmt.Sprintf("%02d:%02d", datetime.Hour(), datetime.Minute())
 }
 
-func fmtDateTime(timestamp int) string {
-	datetime := time.Unix(int64(timestamp), 0)
+func fmtDateTime(timestamp int, loc *time.Location) string {
+	datetime := time.Unix(int64(timestamp), 0).In(loc)
 	return fmt.Sprintf("%02d/%02d/%02d %02d:%02d", datetime.Day(), datetime.Month(), datetime.Year(), datetime.Hour(), datetime.Minute())
 }
 
@@ -1,8 +1,22 @@
 package service
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/garciacer87/weatherAPI/apicache"
+	"github.com/garciacer87/weatherAPI/logging"
+	"github.com/garciacer87/weatherAPI/openweather"
+	"github.com/garciacer87/weatherAPI/tracing"
 )
 
 var (
@@ -10,9 +24,25 @@ var (
 	forecastResp = []byte(`{"cod":"200","message":0,"cnt":2,"list":[{"dt":1611565200,"main":{"temp":2.27,"feels_like":-3.25,"temp_min":2.27,"temp_max":2.71,"pressure":1004,"sea_level":1004,"grnd_level":1001,"humidity":87,"temp_kf":-0.44},"weather":[{"id":803,"main":"Clouds","description":"broken clouds","icon":"04d"}],"clouds":{"all":71},"wind":{"speed":5.12,"deg":336},"visibility":10000,"pop":0,"sys":{"pod":"d"},"dt_txt":"2021-01-25 09:00:00"},{"dt":1611576000,"main":{"temp":4.1,"feels_like":-1.63,"temp_min":4.1,"temp_max":4.72,"pressure":1007,"sea_level":1007,"grnd_level":1004,"humidity":74,"temp_kf":-0.62},"weather":[{"id":803,"main":"Clouds","description":"broken clouds","icon":"04d"}],"clouds":{"all":70},"wind":{"speed":5.33,"deg":343},"visibility":10000,"pop":0,"sys":{"pod":"d"},"dt_txt":"2021-01-25 12:00:00"}],"city":{"id":2988507,"name":"Paris","coord":{"lat":48.8534,"lon":2.3488},"country":"FR","population":2138551,"timezone":3600,"sunrise":1611559763,"sunset":1611592574}}`)
 )
 
-type mockService struct{}
+type mockService struct {
+	mu   sync.Mutex
+	cnt  int
+	unit string
+}
+
+func (ms *mockService) record(cnt int, unit string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if cnt > 0 {
+		ms.cnt = cnt
+	}
+	if unit != "" {
+		ms.unit = unit
+	}
+}
 
-func (ms *mockService) GetWeather(city, country string) (int, []byte) {
+func (ms *mockService) GetWeather(ctx context.Context, city, state, country, unit string) (int, []byte) {
+	ms.record(0, unit)
 	if city == "Paris" {
 		return 200, weatherResp
 	} else if city == "asdf" {
@@ -22,7 +52,8 @@ func (ms *mockService) GetWeather(city, country string) (int, []byte) {
 	return 200, nil
 }
 
-func (ms *mockService) GetForecast(city, country string) (int, []byte) {
+func (ms *mockService) GetForecast(ctx context.Context, city, state, country, unit string, cnt int) (int, []byte) {
+	ms.record(cnt, "")
 	if city == "Paris" {
 		return 200, forecastResp
 	} else if city == "qwer" {
@@ -32,25 +63,104 @@ func (ms *mockService) GetForecast(city, country string) (int, []byte) {
 	return 200, nil
 }
 
+func (ms *mockService) GetWeatherByCoord(ctx context.Context, lat, lon float64, unit string) (int, []byte) {
+	if lat == 48.85 && lon == 2.35 {
+		return 200, weatherResp
+	}
+
+	return 404, nil
+}
+
+func (ms *mockService) GetForecastByCoord(ctx context.Context, lat, lon float64, unit string, cnt int) (int, []byte) {
+	ms.record(cnt, "")
+	if lat == 48.85 && lon == 2.35 {
+		return 200, forecastResp
+	}
+
+	return 404, nil
+}
+
+//slowClient answers every call after delay, like a real upstream would
+type slowClient struct {
+	delay time.Duration
+}
+
+func (sc *slowClient) GetWeather(ctx context.Context, city, state, country, unit string) (int, []byte) {
+	time.Sleep(sc.delay)
+	return 200, weatherResp
+}
+
+func (sc *slowClient) GetForecast(ctx context.Context, city, state, country, unit string, cnt int) (int, []byte) {
+	time.Sleep(sc.delay)
+	if city == "qwer" {
+		return 404, nil
+	}
+	return 200, forecastResp
+}
+
+func (sc *slowClient) GetWeatherByCoord(ctx context.Context, lat, lon float64, unit string) (int, []byte) {
+	time.Sleep(sc.delay)
+	return 200, weatherResp
+}
+
+func (sc *slowClient) GetForecastByCoord(ctx context.Context, lat, lon float64, unit string, cnt int) (int, []byte) {
+	time.Sleep(sc.delay)
+	return 200, forecastResp
+}
+
+//noCache never stores a value, so every call reaches the client
+type noCache struct{}
+
+func (nc *noCache) SetValue(ctx context.Context, id string, v []byte) {}
+
+func (nc *noCache) GetValue(ctx context.Context, id string) ([]byte, time.Time) {
+	return nil, time.Time{}
+}
+
+func (nc *noCache) Delete(ctx context.Context, id string) {}
+
+func (nc *noCache) Flush(ctx context.Context) {}
+
+func (nc *noCache) Stats(ctx context.Context) apicache.Stats {
+	return apicache.Stats{}
+}
+
 type mockCache struct {
 	v map[string][]byte
 }
 
-func (mc *mockCache) SetValue(id string, v []byte) {
+func (mc *mockCache) SetValue(ctx context.Context, id string, v []byte) {
 	mc.v[id] = v
 }
 
-func (mc *mockCache) GetValue(id string) []byte {
-	return mc.v[id]
+func (mc *mockCache) GetValue(ctx context.Context, id string) ([]byte, time.Time) {
+	return mc.v[id], time.Now()
+}
+
+func (mc *mockCache) Delete(ctx context.Context, id string) {
+	delete(mc.v, id)
 }
 
+func (mc *mockCache) Flush(ctx context.Context) {
+	mc.v = make(map[string][]byte)
+}
+
+func (mc *mockCache) Stats(ctx context.Context) apicache.Stats {
+	return apicache.Stats{Items: len(mc.v)}
+}
+
+var (
+	santiago   = time.FixedZone("", -3*3600)
+	testConfig = Config{Host: "host", APIKey: "apikey", Unit: "metric", Timeout: time.Second}
+)
+
 type params struct {
 	city    string
 	country string
 }
 
 func TestGetWeather(t *testing.T) {
-	s := New("host", "apikey", "metric", 2)
+	s := New(testConfig, apicache.New(2))
 
 	ms := s.(*service)
 	ms.apiClient = &mockService{}
@@ -69,19 +179,547 @@ func TestGetWeather(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			statusCode, _ := s.GetWeather(test.params.city, test.params.country)
+			statusCode, _ := s.GetWeather(context.Background(), test.params.city, "", test.params.country, Options{})
+			if statusCode != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, statusCode, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetWeatherProcessingError(t *testing.T) {
+	s := New(testConfig, &noCache{})
+	s.(*service).apiClient = &mockService{}
+
+	//the mock client answers an empty body for unknown cities, which cannot be processed
+	statusCode, body := s.GetWeather(context.Background(), "Atlantis", "", "FR", Options{})
+	if statusCode != 500 {
+		t.Errorf("Unexpected status code. Got: %d, Expected: %d", statusCode, 500)
+	}
+	if !json.Valid(body) {
+		t.Errorf("Expected a valid JSON body. Got: %s", body)
+	}
+}
+
+func TestGetWeatherByCoord(t *testing.T) {
+	s := New(testConfig, apicache.New(2))
+
+	ms := s.(*service)
+	ms.apiClient = &mockService{}
+	cache := &mockCache{make(map[string][]byte)}
+	ms.cache = cache
+
+	tests := []struct {
+		name     string
+		lat      float64
+		lon      float64
+		expected int
+	}{
+		{"Succesful response", 48.8534, 2.3488, 200},
+		{"Failed response", 10, 10, 404},
+		{"Successful response from cache", 48.8512, 2.3521, 200},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			statusCode, _ := s.GetWeatherByCoord(context.Background(), test.lat, test.lon, Options{})
+			if statusCode != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, statusCode, test.expected)
+			}
+		})
+	}
+
+	if len(cache.v) != 1 {
+		t.Errorf("Error in cache size: Got: %d, Expected: %d", len(cache.v), 1)
+	}
+}
+
+func TestGetWeatherSteps(t *testing.T) {
+	s := New(testConfig, apicache.New(2))
+
+	ms := s.(*service)
+	client := &mockService{}
+	ms.apiClient = client
+	cache := &mockCache{make(map[string][]byte)}
+	ms.cache = cache
+
+	tests := []struct {
+		name     string
+		opts     Options
+		expected int
+	}{
+		{"Default steps", Options{}, DefaultSteps},
+		{"Custom steps", Options{Steps: 8}, 8},
+		{"Max steps", Options{Steps: MaxSteps}, MaxSteps},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s.GetWeather(context.Background(), "Paris", "", "FR", test.opts)
+			if client.cnt != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, client.cnt, test.expected)
+			}
+		})
+	}
+
+	if len(cache.v) != len(tests) {
+		t.Errorf("Error in cache size: Got: %d, Expected: %d", len(cache.v), len(tests))
+	}
+}
+
+func TestGetWeatherUnits(t *testing.T) {
+	s := New(testConfig, apicache.New(2))
+
+	ms := s.(*service)
+	client := &mockService{}
+	ms.apiClient = client
+	cache := &mockCache{make(map[string][]byte)}
+	ms.cache = cache
+
+	tests := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{"Default unit", Options{}, "metric"},
+		{"Imperial unit", Options{Unit: "imperial"}, "imperial"},
+		{"Standard unit", Options{Unit: "standard"}, "standard"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s.GetWeather(context.Background(), "Paris", "", "FR", test.opts)
+			if client.unit != test.expected {
+				t.Errorf("Error in test:  %s. Got: %s, Expected: %s", test.name, client.unit, test.expected)
+			}
+		})
+	}
+
+	for _, key := range []string{"paris_fr_3_metric_text_local", "paris_fr_3_imperial_text_local", "paris_fr_3_standard_text_local"} {
+		if cache.v[key] == nil {
+			t.Errorf("Expected cache entry: %s", key)
+		}
+	}
+}
+
+func TestGetWeatherFormat(t *testing.T) {
+	s := New(testConfig, apicache.New(2))
+
+	ms := s.(*service)
+	ms.apiClient = &mockService{}
+	ms.cache = &mockCache{make(map[string][]byte)}
+
+	_, text := s.GetWeather(context.Background(), "Paris", "", "FR", Options{})
+	_, raw := s.GetWeather(context.Background(), "Paris", "", "FR", Options{Format: FormatRaw})
+
+	var textResp Response
+	json.Unmarshal(text, &textResp)
+	if textResp.Temp != "2ºC" {
+		t.Errorf("Error in text temperature: Got: %s, Expected: %s", textResp.Temp, "2ºC")
+	}
+
+	var rawResp RawResponse
+	json.Unmarshal(raw, &rawResp)
+	if rawResp.Temp != 1.85 {
+		t.Errorf("Error in raw temperature: Got: %v, Expected: %v", rawResp.Temp, 1.85)
+	}
+}
+
+func TestGetWeatherConcurrentCalls(t *testing.T) {
+	delay := 50 * time.Millisecond
+
+	s := New(testConfig, apicache.New(2))
+
+	ms := s.(*service)
+	ms.apiClient = &slowClient{delay}
+	ms.cache = &noCache{}
+
+	start := time.Now()
+	statusCode, _ := s.GetWeather(context.Background(), "Paris", "", "FR", Options{})
+	elapsed := time.Since(start)
+
+	if statusCode != 200 {
+		t.Errorf("Unexpected status code. Got: %d, Expected: %d", statusCode, 200)
+	}
+
+	if elapsed >= 2*delay {
+		t.Errorf("Upstream calls are not concurrent. Took: %v, Expected less than: %v", elapsed, 2*delay)
+	}
+
+	statusCode, _ = s.GetWeather(context.Background(), "qwer", "", "zz", Options{})
+	if statusCode != 404 {
+		t.Errorf("Unexpected status code. Got: %d, Expected: %d", statusCode, 404)
+	}
+}
+
+//blockingClient fails the weather call and blocks the forecast call until its context is done
+type blockingClient struct {
+	slowClient
+	cancelled chan error
+}
+
+func (bc *blockingClient) GetWeather(ctx context.Context, city, state, country, unit string) (int, []byte) {
+	return 404, nil
+}
+
+func (bc *blockingClient) GetForecast(ctx context.Context, city, state, country, unit string, cnt int) (int, []byte) {
+	<-ctx.Done()
+	bc.cancelled <- ctx.Err()
+	return 503, nil
+}
+
+func TestGetWeatherCancelsPendingCall(t *testing.T) {
+	s := New(testConfig, apicache.New(2))
+
+	client := &blockingClient{cancelled: make(chan error, 1)}
+	ms := s.(*service)
+	ms.apiClient = client
+	ms.cache = &noCache{}
+
+	statusCode, _ := s.GetWeather(context.Background(), "Paris", "", "FR", Options{})
+	if statusCode != 404 {
+		t.Errorf("Unexpected status code. Got: %d, Expected: %d", statusCode, 404)
+	}
+
+	select {
+	case err := <-client.cancelled:
+		if err != context.Canceled {
+			t.Errorf("Unexpected context error. Got: %v, Expected: %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Pending forecast call was not cancelled")
+	}
+}
+
+//agedCache stores values as if they were stored at storedAt
+type agedCache struct {
+	mu       sync.Mutex
+	v        map[string][]byte
+	storedAt time.Time
+}
+
+func (ac *agedCache) SetValue(ctx context.Context, id string, v []byte) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.v[id] = v
+	ac.storedAt = time.Now()
+}
+
+func (ac *agedCache) GetValue(ctx context.Context, id string) ([]byte, time.Time) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	return ac.v[id], ac.storedAt
+}
+
+func (ac *agedCache) Delete(ctx context.Context, id string) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	delete(ac.v, id)
+}
+
+func (ac *agedCache) Flush(ctx context.Context) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.v = make(map[string][]byte)
+}
+
+func (ac *agedCache) Stats(ctx context.Context) apicache.Stats {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	return apicache.Stats{Items: len(ac.v)}
+}
+
+func TestGetWeatherCacheInfo(t *testing.T) {
+	cfg := testConfig
+	cfg.SoftTTL = time.Minute
+	s := New(cfg, apicache.New(2))
+
+	client := &countingClient{slowClient: slowClient{10 * time.Millisecond}}
+	cache := &agedCache{v: make(map[string][]byte)}
+	ms := s.(*service)
+	ms.apiClient = client
+	ms.cache = cache
+
+	var info CacheInfo
+	ctx := WithCacheInfo(context.Background(), &info)
+
+	s.GetWeather(ctx, "Paris", "", "FR", Options{})
+	if info.Status != CacheMiss {
+		t.Errorf("Error in cache status: Got: %s, Expected: %s", info.Status, CacheMiss)
+	}
+
+	s.GetWeather(ctx, "Paris", "", "FR", Options{})
+	if info.Status != CacheFresh {
+		t.Errorf("Error in cache status: Got: %s, Expected: %s", info.Status, CacheFresh)
+	}
+
+	cache.mu.Lock()
+	cache.v["paris_fr_3_metric_text_local"] = []byte(`{"stale":true}`)
+	cache.storedAt = time.Now().Add(-2 * time.Minute)
+	cache.mu.Unlock()
+
+	statusCode, body := s.GetWeather(ctx, "Paris", "", "FR", Options{})
+	if statusCode != 200 || string(body) != `{"stale":true}` {
+		t.Errorf("Expected stale response. Got: %d %s", statusCode, body)
+	}
+
+	if info.Status != CacheStale || info.Age < 2*time.Minute {
+		t.Errorf("Error in cache info: Got: %+v, Expected status: %s", info, CacheStale)
+	}
+
+	//the stale entry is refreshed in the background
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&client.weatherCalls) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	_, body = s.GetWeather(ctx, "Paris", "", "FR", Options{})
+	if string(body) == `{"stale":true}` || info.Status != CacheFresh {
+		t.Errorf("Expected refreshed response. Got: %s, status: %s", body, info.Status)
+	}
+}
+
+//failingClient answers every call with status code
+type failingClient struct {
+	slowClient
+	code int
+}
+
+func (fc *failingClient) GetWeather(ctx context.Context, city, state, country, unit string) (int, []byte) {
+	return fc.code, nil
+}
+
+func TestGetWeatherLastKnownGood(t *testing.T) {
+	var logs bytes.Buffer
+	cfg := testConfig
+	cfg.LastKnownGood = &mockCache{make(map[string][]byte)}
+	cfg.Logger = logging.New(&logs, logging.Warn)
+
+	newService := func(client openweather.Client) Service {
+		s := New(cfg, apicache.New(2))
+		ms := s.(*service)
+		ms.apiClient = client
+		ms.cache = &noCache{}
+		return s
+	}
+
+	_, body := newService(&mockService{}).GetWeather(context.Background(), "Paris", "", "FR", Options{})
+
+	tests := []struct {
+		name     string
+		params   params
+		code     int
+		expected int
+		status   string
+	}{
+		{"Service unavailable", params{"Paris", "FR"}, 503, 200, CacheLastKnownGood},
+		{"Gateway timeout", params{"Paris", "FR"}, 504, 200, CacheLastKnownGood},
+		{"Internal server error", params{"Paris", "FR"}, 500, 200, CacheLastKnownGood},
+		{"City not found", params{"Paris", "FR"}, 404, 404, CacheMiss},
+		{"No last known good response", params{"London", "GB"}, 503, 503, CacheMiss},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var info CacheInfo
+			ctx := WithCacheInfo(context.Background(), &info)
+			s := newService(&failingClient{code: test.code})
+			logs.Reset()
+
+			statusCode, lastBody := s.GetWeather(ctx, test.params.city, "", test.params.country, Options{})
 			if statusCode != test.expected {
 				t.Errorf("Error in test:  %s. Got: %d, Expected: %d", test.name, statusCode, test.expected)
 			}
+			if info.Status != test.status {
+				t.Errorf("Error in test:  %s. Got status: %s, Expected: %s", test.name, info.Status, test.status)
+			}
+			if statusCode == 200 && string(lastBody) != string(body) {
+				t.Errorf("Error in test:  %s. Expected last known good response", test.name)
+			}
+			if logged := strings.Contains(logs.String(), "Serving last known good response"); logged != (statusCode == 200) {
+				t.Errorf("Error in test:  %s. Unexpected logs: %s", test.name, logs.String())
+			}
+		})
+	}
+}
+
+func TestGetWeatherBudgetPressure(t *testing.T) {
+	budget := openweather.NewBudget(1, 0, 0)
+	budget.Acquire(context.Background())
+
+	cfg := testConfig
+	cfg.SoftTTL = time.Minute
+	cfg.Budget = budget
+	cfg.LastKnownGood = &mockCache{map[string][]byte{"paris_fr_3_metric_text_local": []byte(`{"last":true}`)}}
+	s := New(cfg, apicache.New(2))
+
+	client := &countingClient{slowClient: slowClient{10 * time.Millisecond}}
+	cache := &agedCache{v: map[string][]byte{"london_gb_3_metric_text_local": []byte(`{"stale":true}`)}, storedAt: time.Now().Add(-2 * time.Minute)}
+	ms := s.(*service)
+	ms.apiClient = client
+	ms.cache = cache
+
+	tests := []struct {
+		name     string
+		params   params
+		expected string
+		status   string
+	}{
+		{"Last known good instead of a call", params{"Paris", "FR"}, `{"last":true}`, CacheLastKnownGood},
+		{"Stale response not refreshed", params{"London", "GB"}, `{"stale":true}`, CacheStale},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var info CacheInfo
+			ctx := WithCacheInfo(context.Background(), &info)
+
+			statusCode, body := s.GetWeather(ctx, test.params.city, "", test.params.country, Options{})
+			if statusCode != 200 || string(body) != test.expected {
+				t.Errorf("Error in test:  %s. Got: %d %s, Expected: %d %s", test.name, statusCode, body, 200, test.expected)
+			}
+			if info.Status != test.status {
+				t.Errorf("Error in test:  %s. Got status: %s, Expected: %s", test.name, info.Status, test.status)
+			}
 		})
 	}
+
+	time.Sleep(50 * time.Millisecond)
+	if calls := atomic.LoadInt32(&client.weatherCalls); calls != 0 {
+		t.Errorf("Unexpected calls to OpenWeather API under budget pressure. Got: %d, Expected: %d", calls, 0)
+	}
+}
+
+//spanTree returns the path from the root of every span, like "test > service.GetWeather > cache.lookup", sorted
+func spanTree(spans []tracing.SpanData) []string {
+	byID := make(map[tracing.SpanID]tracing.SpanData)
+	for _, span := range spans {
+		byID[span.SpanID] = span
+	}
+
+	var paths []string
+	for _, span := range spans {
+		path := span.Name
+		for parent, ok := byID[span.ParentID]; ok; parent, ok = byID[parent.ParentID] {
+			path = parent.Name + " > " + path
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+func TestGetWeatherSpans(t *testing.T) {
+	var traceparents []string
+	var mu sync.Mutex
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceparents = append(traceparents, r.Header.Get("traceparent"))
+		mu.Unlock()
+
+		if strings.HasSuffix(r.URL.Path, "/forecast") {
+			w.Write(forecastResp)
+			return
+		}
+		w.Write(weatherResp)
+	}))
+	defer upstream.Close()
+
+	cfg := testConfig
+	cfg.Host = upstream.URL
+	s := New(cfg, apicache.New(2))
+
+	exporter := tracing.NewMemoryExporter()
+	tracer := tracing.New(exporter)
+
+	tests := []struct {
+		name     string
+		expected []string
+		hit      bool
+		status   string
+	}{
+		{"Cache miss", []string{
+			"test",
+			"test > service.GetWeather",
+			"test > service.GetWeather > GET /data/2.5/forecast",
+			"test > service.GetWeather > GET /data/2.5/weather",
+			"test > service.GetWeather > cache.lookup",
+		}, false, CacheMiss},
+		{"Cache hit", []string{
+			"test",
+			"test > service.GetWeather",
+			"test > service.GetWeather > cache.lookup",
+		}, true, CacheFresh},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exporter.Reset()
+
+			ctx, root := tracer.Start(context.Background(), "test", tracing.KindServer)
+			s.GetWeather(ctx, "Paris", "", "FR", Options{})
+			root.End()
+
+			spans := exporter.Spans()
+			tree := spanTree(spans)
+			if strings.Join(tree, "\n") != strings.Join(test.expected, "\n") {
+				t.Fatalf("Error in test:  %s. Got:\n%s\nExpected:\n%s", test.name, strings.Join(tree, "\n"), strings.Join(test.expected, "\n"))
+			}
+
+			for _, span := range spans {
+				var key string
+				var expected interface{}
+				switch {
+				case span.Name == "cache.lookup":
+					key, expected = "cache.hit", test.hit
+				case span.Name == "service.GetWeather":
+					key, expected = "cache.status", test.status
+				case strings.HasPrefix(span.Name, "GET "):
+					key, expected = "http.status_code", 200
+				default:
+					continue
+				}
+
+				if v, _ := span.Attribute(key); v != expected {
+					t.Errorf("Error in test:  %s. Got %s %s: %v, Expected: %v", test.name, span.Name, key, v, expected)
+				}
+			}
+		})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(traceparents) != 2 {
+		t.Errorf("Error in upstream calls. Got: %d, Expected: %d", len(traceparents), 2)
+	}
+	for _, traceparent := range traceparents {
+		if !strings.HasPrefix(traceparent, "00-") || len(traceparent) != 55 {
+			t.Errorf("Unexpected traceparent sent upstream: %s", traceparent)
+		}
+	}
+}
+
+//BenchmarkGetWeather measures a cache miss against an upstream that takes 10ms per call.
+//With concurrent calls each operation takes ~10ms instead of ~20ms
+func BenchmarkGetWeather(b *testing.B) {
+	s := New(testConfig, apicache.New(2))
+
+	ms := s.(*service)
+	ms.apiClient = &slowClient{10 * time.Millisecond}
+	ms.cache = &noCache{}
+
+	for i := 0; i < b.N; i++ {
+		s.GetWeather(context.Background(), "Paris", "", "FR", Options{})
+	}
 }
 
 func TestRespBuilder(t *testing.T) {
 	var resp Response
 	unit := "metric"
 
-	data, _ := buildResponse(weatherResp, forecastResp, unit)
+	data, _ := buildResponse(weatherResp, forecastResp, "", unit, TZLocal)
 
 	json.Unmarshal(data, &resp)
 
@@ -101,22 +739,57 @@ func TestRespBuilder(t *testing.T) {
 		t.Errorf("Error in forecast list size: Got: %d, Expected: %d", len(resp.Forecast), 2)
 	}
 
-	_, err := buildResponse(weatherResp, []byte(""), unit)
+	if resp.Sunrise != "08:29" {
+		t.Errorf("Error in sunrise: Got: %s, Expected: %s", resp.Sunrise, "08:29")
+	}
+
+	data, _ = buildResponse(weatherResp, forecastResp, "", unit, "America/Santiago")
+	json.Unmarshal(data, &resp)
+
+	if resp.Sunrise != "04:29" {
+		t.Errorf("Error in sunrise: Got: %s, Expected: %s", resp.Sunrise, "04:29")
+	}
+
+	data, _ = buildResponse(weatherResp, forecastResp, "IL", unit, TZLocal)
+	json.Unmarshal(data, &resp)
+
+	if resp.Location != "Paris, IL, FR" {
+		t.Errorf("Error in location: Got: %s, Expected: %s", resp.Location, "Paris, IL, FR")
+	}
+
+	_, err := buildResponse(weatherResp, forecastResp, "", unit, "Mars/Olympus")
+	if err == nil {
+		t.Errorf("Expected error ")
+	}
+
+	_, err = buildResponse(weatherResp, []byte(""), "", unit, TZLocal)
 	if err == nil {
 		t.Errorf("Expected error ")
 	}
 
-	_, err = buildResponse([]byte(""), forecastResp, unit)
+	_, err = buildResponse([]byte(""), forecastResp, "", unit, TZLocal)
 	if err == nil {
 		t.Errorf("Expected error ")
 	}
 }
 
 func TestGetRequestId(t *testing.T) {
-	id := getRequestID("Paris", "FR")
+	id := getRequestID("Paris", "", "FR")
 	if id != "paris_fr" {
 		t.Errorf("Id is different than expected. Got: %s, Expected: %s", id, "paris_fr")
 	}
+
+	id = getRequestID("Springfield", "IL", "us")
+	if id != "springfield_il_us" {
+		t.Errorf("Id is different than expected. Got: %s, Expected: %s", id, "springfield_il_us")
+	}
+}
+
+func TestGetCoordRequestId(t *testing.T) {
+	id := getCoordRequestID(roundCoord(-33.4569), roundCoord(-70.6483))
+	if id != "-33.46_-70.65" {
+		t.Errorf("Id is different than expected. Got: %s, Expected: %s", id, "-33.46_-70.65")
+	}
 }
 
 func TestFmtTemperature(t *testing.T) {
@@ -126,15 +799,72 @@ func TestFmtTemperature(t *testing.T) {
 	}
 }
 
+func TestFmtTemperatureStandard(t *testing.T) {
+	temp := fmtTemperature(275.15, "standard")
+	if temp != "275K" {
+		t.Errorf("Temperature is different than expected. Got: %s, Expected: %s", temp, "275K")
+	}
+}
+
+func TestValidUnit(t *testing.T) {
+	for _, unit := range Units() {
+		if !ValidUnit(unit) {
+			t.Errorf("Expected valid unit: %s", unit)
+		}
+	}
+
+	if ValidUnit("kelvin") {
+		t.Errorf("Expected invalid unit: %s", "kelvin")
+	}
+}
+
+func TestLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		tz       string
+		expected string
+	}{
+		{"Local time", TZLocal, "08:01"},
+		{"UTC time", TZUTC, "07:01"},
+		{"IANA time", "America/Santiago", "04:01"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			loc, err := location(test.tz, 3600)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if v := fmtTime(1611558107, loc); v != test.expected {
+				t.Errorf("Error in test:  %s. Got: %s, Expected: %s", test.name, v, test.expected)
+			}
+		})
+	}
+}
+
+func TestValidTZ(t *testing.T) {
+	for _, tz := range []string{TZLocal, TZUTC, "America/Santiago"} {
+		if !ValidTZ(tz) {
+			t.Errorf("Expected valid timezone: %s", tz)
+		}
+	}
+
+	for _, tz := range []string{"", "Local", "Mars/Olympus"} {
+		if ValidTZ(tz) {
+			t.Errorf("Expected invalid timezone: %s", tz)
+		}
+	}
+}
+
 func TestFmtTime(t *testing.T) {
-	time := fmtTime(1611558107)
+	time := fmtTime(1611558107, santiago)
 	if time != "04:01" {
 		t.Errorf("Time is different than expected. Got: %s, Expected: %s", time, "04:01")
 	}
 }
 
 func TestFmtDateTime(t *testing.T) {
-	time := fmtDateTime(1611558107)
+	time := fmtDateTime(1611558107, santiago)
 	if time != "25/01/2021 04:01" {
 		t.Errorf("Time is different than expected. Got: %s, Expected: %s", time, "25/01/2021 04:01")
 	}
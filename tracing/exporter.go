@@ -0,0 +1,285 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garciacer87/weatherAPI/logging"
+)
+
+const (
+	//otlpInterval is how often the OTLP exporter sends the spans ended since the last export
+	otlpInterval = 5 * time.Second
+	//otlpBatchSize is the number of spans that triggers an export before otlpInterval
+	otlpBatchSize = 512
+	//otlpMaxQueue is the number of spans kept while the collector is slow or down. Newer spans are dropped
+	otlpMaxQueue = 4 * otlpBatchSize
+	//otlpTimeout bounds every export
+	otlpTimeout = 10 * time.Second
+)
+
+//MemoryExporter keeps every span in memory, used to check spans in tests
+type MemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+//NewMemoryExporter returns an empty in-memory exporter
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{}
+}
+
+//Export keeps span
+func (e *MemoryExporter) Export(span SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+//Spans returns the spans exported so far, in the order they ended
+func (e *MemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+//Reset drops every span
+func (e *MemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+//OTLPExporter sends spans in batches to an OpenTelemetry collector, using OTLP over HTTP with JSON encoding
+type OTLPExporter struct {
+	url         string
+	serviceName string
+	client      *http.Client
+	logger      *logging.Logger
+
+	mu      sync.Mutex
+	queue   []SpanData
+	dropped int
+
+	flush chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+//NewOTLP returns an exporter sending spans of serviceName to the collector at endpoint, like "http://localhost:4318".
+//Export errors are logged to logger. Shutdown must be called to send the last spans
+func NewOTLP(endpoint, serviceName string, logger *logging.Logger) *OTLPExporter {
+	e := &OTLPExporter{
+		url:         strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: otlpTimeout},
+		logger:      logger.With("component", "tracing"),
+		flush:       make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go e.run()
+
+	return e
+}
+
+//Export queues span, to be sent on the next batch
+func (e *OTLPExporter) Export(span SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.queue) >= otlpMaxQueue {
+		e.dropped++
+		return
+	}
+
+	e.queue = append(e.queue, span)
+	if len(e.queue) >= otlpBatchSize {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+//Shutdown sends the queued spans and stops the exporter, waiting until ctx is done at most
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.once.Do(func() { close(e.stop) })
+
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(otlpInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.flush:
+		case <-e.stop:
+			e.send()
+			return
+		}
+		e.send()
+	}
+}
+
+//send posts every queued span to the collector. Spans failing to be sent are dropped
+func (e *OTLPExporter) send() {
+	e.mu.Lock()
+	spans, dropped := e.queue, e.dropped
+	e.queue, e.dropped = nil, 0
+	e.mu.Unlock()
+
+	if dropped > 0 {
+		e.logger.Warn("Tracing queue is full, spans dropped", "spans", dropped)
+	}
+
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > otlpBatchSize {
+			n = otlpBatchSize
+		}
+
+		if err := e.post(spans[:n]); err != nil {
+			e.logger.Error("Error exporting spans", "url", e.url, "spans", n, "error", err)
+		}
+		spans = spans[n:]
+	}
+}
+
+func (e *OTLPExporter) post(spans []SpanData) error {
+	body, err := json.Marshal(newOTLPRequest(e.serviceName, spans))
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("collector answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+//otlpRequest is an ExportTraceServiceRequest, encoded as described by the OTLP JSON mapping:
+//IDs as hex strings, and 64 bit integers as strings
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+func newOTLPRequest(serviceName string, spans []SpanData) otlpRequest {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.TraceID.String(),
+			SpanID:            span.SpanID.String(),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Status:            otlpStatus{span.Status, span.StatusMessage},
+		}
+		if span.ParentID.IsValid() {
+			s.ParentSpanID = span.ParentID.String()
+		}
+		for _, attr := range span.Attributes {
+			s.Attributes = append(s.Attributes, otlpKeyValue{attr.Key, newOTLPValue(attr.Value)})
+		}
+
+		otlpSpans = append(otlpSpans, s)
+	}
+
+	return otlpRequest{[]otlpResourceSpans{{
+		Resource:   otlpResource{[]otlpKeyValue{{"service.name", newOTLPValue(serviceName)}}},
+		ScopeSpans: []otlpScopeSpans{{otlpScope{"github.com/garciacer87/weatherAPI/tracing"}, otlpSpans}},
+	}}}
+}
+
+//newOTLPValue encodes v by its type. Types without an OTLP equivalent are encoded as strings
+func newOTLPValue(v interface{}) otlpAnyValue {
+	switch value := v.(type) {
+	case bool:
+		return otlpAnyValue{BoolValue: &value}
+	case int:
+		s := strconv.Itoa(value)
+		return otlpAnyValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(value, 10)
+		return otlpAnyValue{IntValue: &s}
+	case float64:
+		return otlpAnyValue{DoubleValue: &value}
+	case string:
+		return otlpAnyValue{StringValue: &value}
+	}
+
+	s := fmt.Sprint(v)
+	return otlpAnyValue{StringValue: &s}
+}
@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOTLPExporter(t *testing.T) {
+	var mu sync.Mutex
+	var requests []otlpRequest
+
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		var req otlpRequest
+		json.Unmarshal(body, &req)
+
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLP(collector.URL+"/", "weatherapi", nil)
+	tracer := New(exporter)
+
+	ctx, root := tracer.Start(context.Background(), "GET /weather", KindServer, "city", "Paris", "cache.hit", false)
+	_, child := Start(ctx, "GET /data/2.5/weather", KindClient, "http.status_code", 200)
+	child.End()
+	root.SetStatus(StatusError, "upstream failed")
+	root.End()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := exporter.Shutdown(shutdownCtx); err != nil {
+		t.Fatalf("Unexpected error on shutdown: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 {
+		t.Fatalf("Error in exports. Got: %d, Expected: %d", len(requests), 1)
+	}
+
+	rs := requests[0].ResourceSpans[0]
+	if name := *rs.Resource.Attributes[0].Value.StringValue; name != "weatherapi" {
+		t.Errorf("Error in service name. Got: %s, Expected: %s", name, "weatherapi")
+	}
+
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Error in spans. Got: %d, Expected: %d", len(spans), 2)
+	}
+
+	tests := []struct {
+		name     string
+		got      interface{}
+		expected interface{}
+	}{
+		{"Child parent", spans[0].ParentSpanID, spans[1].SpanID},
+		{"Root parent", spans[1].ParentSpanID, ""},
+		{"Trace ID", spans[0].TraceID, spans[1].TraceID},
+		{"Trace ID length", len(spans[0].TraceID), 32},
+		{"Kind", spans[1].Kind, KindServer},
+		{"Status", spans[1].Status, otlpStatus{StatusError, "upstream failed"}},
+		{"String attribute", *spans[1].Attributes[0].Value.StringValue, "Paris"},
+		{"Bool attribute", *spans[1].Attributes[1].Value.BoolValue, false},
+		{"Int attribute", *spans[0].Attributes[0].Value.IntValue, "200"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.got != test.expected {
+				t.Errorf("Error in test:  %s. Got: %v, Expected: %v", test.name, test.got, test.expected)
+			}
+		})
+	}
+}
+
+func TestOTLPExporterQueue(t *testing.T) {
+	exporter := &OTLPExporter{flush: make(chan struct{}, 1)}
+	for i := 0; i < otlpMaxQueue+10; i++ {
+		exporter.Export(SpanData{})
+	}
+
+	if len(exporter.queue) != otlpMaxQueue || exporter.dropped != 10 {
+		t.Errorf("Error in queue. Got: %d queued and %d dropped, Expected: %d and %d", len(exporter.queue), exporter.dropped, otlpMaxQueue, 10)
+	}
+
+	select {
+	case <-exporter.flush:
+	default:
+		t.Errorf("Expected a flush once the batch size is reached")
+	}
+}
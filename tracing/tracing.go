@@ -0,0 +1,263 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//SpanKind tells whether a span serves a request, calls another service or is internal, like OTLP span kinds
+type SpanKind int
+
+//Span kinds, with their OTLP values
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+//StatusCode is the outcome of a span, like OTLP status codes
+type StatusCode int
+
+//Status codes, with their OTLP values
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+//TraceID identifies a trace, shared by all of its spans
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+//SpanID identifies a span within a trace
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+//IsValid reports whether id was set. The zero SpanID is used for root spans parent
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+//Attribute is a key value pair describing a span, like city=Paris
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+//SpanData is a finished span, as received by exporters
+type SpanData struct {
+	TraceID       TraceID
+	SpanID        SpanID
+	ParentID      SpanID
+	Name          string
+	Kind          SpanKind
+	Start         time.Time
+	End           time.Time
+	Attributes    []Attribute
+	Status        StatusCode
+	StatusMessage string
+}
+
+//Attribute returns the value of the attribute key, and whether it was set
+func (d SpanData) Attribute(key string) (interface{}, bool) {
+	for i := len(d.Attributes) - 1; i >= 0; i-- {
+		if d.Attributes[i].Key == key {
+			return d.Attributes[i].Value, true
+		}
+	}
+	return nil, false
+}
+
+//Exporter receives every span once it ends
+type Exporter interface {
+	Export(span SpanData)
+}
+
+//Tracer starts spans and sends them to its exporter when they end.
+//A nil Tracer starts no spans, so tracing can be left disabled
+type Tracer struct {
+	exporter Exporter
+}
+
+//New returns a tracer sending spans to exporter
+func New(exporter Exporter) *Tracer {
+	return &Tracer{exporter}
+}
+
+//Span is an operation being traced. A nil Span ignores every call, so callers do not need to check
+//whether tracing is enabled. Spans of unsampled traces are propagated but never exported
+type Span struct {
+	tracer  *Tracer
+	sampled bool
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+//remoteParent is a span of another service, received in a traceparent header
+type remoteParent struct {
+	traceID TraceID
+	spanID  SpanID
+	sampled bool
+}
+
+//sampledFlag is the trace-flags bit of traceparent headers telling the trace is recorded
+const sampledFlag = 0x01
+
+//Start starts a span with the key value pairs kv as attributes. It is a child of the span carried by ctx,
+//or of the remote parent set by ContextWithTraceParent, or a new sampled trace otherwise. Children keep
+//the sampling decision of their parent
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind, kv ...interface{}) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	data := SpanData{Name: name, Kind: kind, Start: time.Now(), SpanID: newSpanID()}
+	sampled := true
+	if parent := SpanFromContext(ctx); parent != nil {
+		data.TraceID, data.ParentID = parent.data.TraceID, parent.data.SpanID
+		sampled = parent.sampled
+	} else if remote, ok := ctx.Value(remoteKey{}).(remoteParent); ok {
+		data.TraceID, data.ParentID = remote.traceID, remote.spanID
+		sampled = remote.sampled
+	} else {
+		rand.Read(data.TraceID[:])
+	}
+
+	s := &Span{tracer: t, sampled: sampled, data: data}
+	s.SetAttributes(kv...)
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+//Start starts a child of the span carried by ctx, with the tracer of that span.
+//When ctx carries no span, tracing is disabled for it and the returned span is nil
+func Start(ctx context.Context, name string, kind SpanKind, kv ...interface{}) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, kind, kv...)
+}
+
+//SpanFromContext returns the span carried by ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+//SetAttributes sets the key value pairs kv as attributes, like SetAttributes("city", "Paris", "country", "fr")
+func (s *Span) SetAttributes(kv ...interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(kv); i += 2 {
+		s.data.Attributes = append(s.data.Attributes, Attribute{fmt.Sprint(kv[i]), kv[i+1]})
+	}
+}
+
+//SetStatus sets the outcome of the span. msg describes errors
+func (s *Span) SetStatus(code StatusCode, msg string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Status, s.data.StatusMessage = code, msg
+}
+
+//SetStatusCode sets the HTTP status code of the response as the http.status_code attribute.
+//Codes from 500 on set the span status as error
+func (s *Span) SetStatusCode(code int) {
+	s.SetAttributes("http.status_code", code)
+	if code >= http.StatusInternalServerError {
+		s.SetStatus(StatusError, http.StatusText(code))
+	}
+}
+
+//End finishes the span and sends it to the exporter, unless the trace is not sampled. Only the first call has effect
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if s.sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.Export(data)
+	}
+}
+
+//TraceParent returns the W3C traceparent header identifying the span, like
+//"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", or an empty string for a nil span.
+//The trace flags tell whether the trace is sampled
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+
+	var flags byte
+	if s.sampled {
+		flags = sampledFlag
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", s.data.TraceID, s.data.SpanID, flags)
+}
+
+//ContextWithTraceParent returns a copy of ctx where new spans continue the trace of the W3C traceparent header,
+//along with its sampling decision. Invalid headers are ignored
+func ContextWithTraceParent(ctx context.Context, traceparent string) context.Context {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ctx
+	}
+
+	var remote remoteParent
+	if _, err := hex.Decode(remote.traceID[:], []byte(parts[1])); err != nil || remote.traceID == (TraceID{}) {
+		return ctx
+	}
+	if _, err := hex.Decode(remote.spanID[:], []byte(parts[2])); err != nil || !remote.spanID.IsValid() {
+		return ctx
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return ctx
+	}
+	remote.sampled = flags[0]&sampledFlag != 0
+
+	return context.WithValue(ctx, remoteKey{}, remote)
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	rand.Read(id[:])
+	return id
+}
@@ -0,0 +1,161 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSpanTree(t *testing.T) {
+	exporter := NewMemoryExporter()
+	tracer := New(exporter)
+
+	ctx, root := tracer.Start(context.Background(), "root", KindServer, "city", "Paris")
+	childCtx, child := Start(ctx, "child", KindInternal)
+	_, grandchild := Start(childCtx, "grandchild", KindClient, "code", 200)
+	grandchild.End()
+	child.SetStatus(StatusError, "failed")
+	child.End()
+	child.End()
+	root.End()
+
+	spans := exporter.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("Error in spans. Got: %d, Expected: %d", len(spans), 3)
+	}
+
+	tests := []struct {
+		name   string
+		span   SpanData
+		parent SpanID
+		kind   SpanKind
+		status StatusCode
+	}{
+		{"grandchild", spans[0], spans[1].SpanID, KindClient, StatusUnset},
+		{"child", spans[1], spans[2].SpanID, KindInternal, StatusError},
+		{"root", spans[2], SpanID{}, KindServer, StatusUnset},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.span.Name != test.name {
+				t.Errorf("Error in test:  %s. Got name: %s", test.name, test.span.Name)
+			}
+			if test.span.ParentID != test.parent {
+				t.Errorf("Error in test:  %s. Got parent: %s, Expected: %s", test.name, test.span.ParentID, test.parent)
+			}
+			if test.span.TraceID != spans[2].TraceID {
+				t.Errorf("Error in test:  %s. Got trace: %s, Expected: %s", test.name, test.span.TraceID, spans[2].TraceID)
+			}
+			if test.span.Kind != test.kind || test.span.Status != test.status {
+				t.Errorf("Error in test:  %s. Got kind and status: %d %d, Expected: %d %d", test.name, test.span.Kind, test.span.Status, test.kind, test.status)
+			}
+			if test.span.End.Before(test.span.Start) {
+				t.Errorf("Error in test:  %s. Span ends before it starts", test.name)
+			}
+		})
+	}
+
+	if v, _ := spans[2].Attribute("city"); v != "Paris" {
+		t.Errorf("Error in root attribute. Got: %v, Expected: %s", v, "Paris")
+	}
+	if v, _ := spans[0].Attribute("code"); v != 200 {
+		t.Errorf("Error in grandchild attribute. Got: %v, Expected: %d", v, 200)
+	}
+}
+
+func TestSetStatusCode(t *testing.T) {
+	exporter := NewMemoryExporter()
+	tracer := New(exporter)
+
+	tests := []struct {
+		name     string
+		code     int
+		expected StatusCode
+	}{
+		{"Successful response", 200, StatusUnset},
+		{"City not found", 404, StatusUnset},
+		{"Service unavailable", 503, StatusError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exporter.Reset()
+			_, span := tracer.Start(context.Background(), "root", KindServer)
+			span.SetStatusCode(test.code)
+			span.End()
+
+			data := exporter.Spans()[0]
+			if v, _ := data.Attribute("http.status_code"); v != test.code || data.Status != test.expected {
+				t.Errorf("Error in test:  %s. Got: %v %d, Expected: %d %d", test.name, v, data.Status, test.code, test.expected)
+			}
+		})
+	}
+}
+
+func TestDisabledTracing(t *testing.T) {
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "root", KindServer)
+	if span != nil {
+		t.Errorf("Nil tracer must not start spans")
+	}
+
+	_, span = Start(ctx, "child", KindInternal)
+	span.SetAttributes("city", "Paris")
+	span.SetStatus(StatusError, "failed")
+	span.End()
+
+	if span != nil || span.TraceParent() != "" {
+		t.Errorf("Spans must not be started without a parent")
+	}
+}
+
+func TestTraceParent(t *testing.T) {
+	exporter := NewMemoryExporter()
+	tracer := New(exporter)
+
+	tests := []struct {
+		name        string
+		traceparent string
+		continued   bool
+		sampled     bool
+	}{
+		{"Valid header", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"Unsampled trace", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"Unknown flags", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03", true, true},
+		{"Missing header", "", false, true},
+		{"Unknown version", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, true},
+		{"Zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, true},
+		{"Non hex span ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bz-01", false, true},
+		{"Non hex flags", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0z", false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := ContextWithTraceParent(context.Background(), test.traceparent)
+			ctx, span := tracer.Start(ctx, "root", KindServer)
+			_, child := Start(ctx, "child", KindClient)
+			before := len(exporter.Spans())
+			child.End()
+			span.End()
+
+			exported := len(exporter.Spans()) - before
+			if test.sampled && exported != 2 || !test.sampled && exported != 0 {
+				t.Errorf("Error in test:  %s. Got: %d exported spans, Expected sampled: %v", test.name, exported, test.sampled)
+			}
+
+			flags := map[bool]string{true: "-01", false: "-00"}[test.sampled]
+			if !strings.HasSuffix(span.TraceParent(), flags) || !strings.HasSuffix(child.TraceParent(), flags) {
+				t.Errorf("Error in test:  %s. Got: %s and %s, Expected flags: %s", test.name, span.TraceParent(), child.TraceParent(), flags)
+			}
+
+			continued := span.TraceParent()[3:35] == "4bf92f3577b34da6a3ce929d0e0e4736"
+			if continued != test.continued {
+				t.Errorf("Error in test:  %s. Got: %s, Expected to continue: %v", test.name, span.TraceParent(), test.continued)
+			}
+			if continued && span.data.ParentID.String() != "00f067aa0ba902b7" {
+				t.Errorf("Error in test:  %s. Got parent: %s", test.name, span.data.ParentID)
+			}
+		})
+	}
+}